@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/jnsoft/gamma/database"
+	"github.com/spf13/cobra"
+)
+
+const flagAddress = "address"
+const flagOffset = "offset"
+const flagLimit = "limit"
+
+func accountCmd() *cobra.Command {
+	var accountCmd = &cobra.Command{
+		Use:   "account",
+		Short: "Inspects accounts (history...).",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return incorrectUsageErr()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+		},
+	}
+
+	accountCmd.AddCommand(accountHistoryCmd())
+
+	return accountCmd
+}
+
+func accountHistoryCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "history",
+		Short: "Lists the transfers sent or received by an account and the blocks it mined, newest first.",
+		Run: func(cmd *cobra.Command, args []string) {
+			address, _ := cmd.Flags().GetString(flagAddress)
+			offset, _ := cmd.Flags().GetInt(flagOffset)
+			limit, _ := cmd.Flags().GetInt(flagLimit)
+
+			if !common.IsHexAddress(address) {
+				fmt.Fprintf(os.Stderr, "invalid address '%s'\n", address)
+				os.Exit(1)
+			}
+			account := database.NewAccount(address)
+
+			state, err := database.NewStateFromDisk(getDataDirFromCmd(cmd))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer state.Close()
+
+			txs, total, err := state.GetAccountHistory(account, offset, limit)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				state.Close()
+				os.Exit(1)
+			}
+
+			fmt.Printf("History of %s (%d-%d of %d):\n", account.String(), offset+1, offset+len(txs), total)
+			fmt.Println("__________________")
+			fmt.Println("")
+			for _, accountTx := range txs {
+				roles := strings.Join(accountTx.Roles, ",")
+
+				if accountTx.Tx == nil {
+					fmt.Printf("block %d [%s]: reward %s\n", accountTx.BlockHeight, roles, accountTx.Reward)
+					continue
+				}
+
+				tx := accountTx.Tx
+				fmt.Printf("block %d tx %d [%s]: %s -> %s %s (nonce %d)\n", accountTx.BlockHeight, accountTx.Index, roles, tx.From.String(), tx.To.String(), tx.Value, tx.Nonce)
+			}
+		},
+	}
+
+	addDefaultRequiredFlags(cmd)
+	cmd.Flags().String(flagAddress, "", "the account to list the history of")
+	cmd.MarkFlagRequired(flagAddress)
+	cmd.Flags().Int(flagOffset, 0, "number of newest transfers to skip")
+	cmd.Flags().Int(flagLimit, 50, "maximum number of transfers to list")
+
+	return cmd
+}
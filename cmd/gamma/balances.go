@@ -6,10 +6,11 @@ import (
 	"sort"
 
 	"github.com/jnsoft/gamma/database"
-	"github.com/jnsoft/gamma/node"
 	"github.com/spf13/cobra"
 )
 
+const flagAtHeight = "at-height"
+
 func balancesCmd() *cobra.Command {
 	var balancesCmd = &cobra.Command{
 		Use:   "balances",
@@ -29,45 +30,58 @@ func balancesCmd() *cobra.Command {
 func balancesListCmd() *cobra.Command {
 	var balancesListCmd = &cobra.Command{
 		Use:   "list",
-		Short: "Lists all balances.",
+		Short: "Lists all balances, at the latest block or at a past one.",
 		Run: func(cmd *cobra.Command, args []string) {
-			state, err := database.NewStateFromDisk(getDataDirFromCmd(cmd), node.DefaultMiningDifficulty)
+			state, err := database.NewStateFromDisk(getDataDirFromCmd(cmd))
 			if err != nil {
 				fmt.Fprintln(os.Stderr, err)
 				os.Exit(1)
 			}
 			defer state.Close()
 
+			view := state.View()
+			if cmd.Flags().Changed(flagAtHeight) {
+				height, _ := cmd.Flags().GetUint64(flagAtHeight)
+
+				view, err = state.ViewAt(height)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					state.Close()
+					os.Exit(1)
+				}
+			}
+
 			// sort keys from hashset
-			keys := make([]database.Address, 0, len(state.Balances))
-			for k := range state.Balances {
+			keys := make([]database.Address, 0, len(view.Balances()))
+			for k := range view.Balances() {
 				keys = append(keys, k)
 			}
 			sort.SliceStable(keys, func(i, j int) bool {
 				return keys[i].Hex() < keys[j].Hex()
 			})
 
-			fmt.Printf("Accounts balances at %x:\n", state.LatestBlockHash())
+			fmt.Printf("Accounts balances at %x (block %d):\n", view.BlockHash(), view.Height())
 			fmt.Println("__________________")
 			fmt.Println("")
 			//for account, balance := range state.Balances {
 			//	fmt.Println(fmt.Sprintf("%s: %d", account.String(), balance))
 			//}
 			for _, account := range keys {
-				fmt.Printf("%s: %d\n", account.String(), state.Balances[account])
+				fmt.Printf("%s: %s\n", account.String(), view.Balance(account))
 			}
 			fmt.Println("")
 			fmt.Printf("Accounts nonces:")
 			fmt.Println("")
 			fmt.Println("__________________")
 			fmt.Println("")
-			for account, nonce := range state.Account2Nonce {
+			for account, nonce := range view.Nonces() {
 				fmt.Printf("%s: %d\n", account.String(), nonce)
 			}
 		},
 	}
 
 	addDefaultRequiredFlags(balancesListCmd)
+	balancesListCmd.Flags().Uint64(flagAtHeight, 0, "height of the block to list the balances after, the latest block by default")
 
 	return balancesListCmd
 }
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+
+	"github.com/jnsoft/gamma/database"
+	"github.com/spf13/cobra"
+)
+
+const flagBlockStore = "to"
+const flagKeep = "keep"
+const flagToHeight = "to-height"
+const flagFromHeight = "from-height"
+const flagFormat = "format"
+const flagFile = "file"
+const flagLegacyDir = "legacy-dir"
+const flagMapping = "mapping"
+
+func dbCmd() *cobra.Command {
+	var dbCmd = &cobra.Command{
+		Use:   "db",
+		Short: "Maintains the node's blockchain database (convert, snapshot, verify, rollback, migrate, export, import, migrate-legacy...).",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return incorrectUsageErr()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+		},
+	}
+
+	dbCmd.AddCommand(dbConvertCmd())
+	dbCmd.AddCommand(dbSnapshotCmd())
+	dbCmd.AddCommand(dbVerifyCmd())
+	dbCmd.AddCommand(dbRollbackCmd())
+	dbCmd.AddCommand(dbMigrateEncodingCmd())
+	dbCmd.AddCommand(dbExportCmd())
+	dbCmd.AddCommand(dbImportCmd())
+	dbCmd.AddCommand(dbMigrateLegacyCmd())
+
+	return dbCmd
+}
+
+func dbConvertCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "convert",
+		Short: "Converts the block store to the block.db file or the embedded key-value backend.",
+		Run: func(cmd *cobra.Command, args []string) {
+			to, _ := cmd.Flags().GetString(flagBlockStore)
+			dataDir := getDataDirFromCmd(cmd)
+
+			from := database.BlockStoreKind(dataDir)
+
+			err := database.ConvertBlockStore(dataDir, to)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Converted the block store from '%s' to '%s'\n", from, to)
+		},
+	}
+
+	addDefaultRequiredFlags(cmd)
+	cmd.Flags().String(flagBlockStore, database.BlockStoreKV, fmt.Sprintf("the block store to convert to, '%s' or '%s'", database.BlockStoreFile, database.BlockStoreKV))
+
+	return cmd
+}
+
+func dbSnapshotCmd() *cobra.Command {
+	var snapshotCmd = &cobra.Command{
+		Use:   "snapshot",
+		Short: "Manages state snapshots used for fast boot (create, list, prune).",
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return incorrectUsageErr()
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+		},
+	}
+
+	snapshotCmd.AddCommand(dbSnapshotCreateCmd())
+	snapshotCmd.AddCommand(dbSnapshotListCmd())
+	snapshotCmd.AddCommand(dbSnapshotPruneCmd())
+
+	return snapshotCmd
+}
+
+func dbSnapshotCreateCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "create",
+		Short: "Snapshots the balances and nonces of the latest block.",
+		Run: func(cmd *cobra.Command, args []string) {
+			state, err := database.NewStateFromDisk(getDataDirFromCmd(cmd))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer state.Close()
+
+			file, err := state.CreateSnapshot()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				state.Close()
+				os.Exit(1)
+			}
+
+			fmt.Printf("Created snapshot of block %d '%s'\n", file.Height, file.Hash.Hex())
+			fmt.Printf("Saved in: %s\n", file.Path)
+		},
+	}
+
+	addDefaultRequiredFlags(cmd)
+
+	return cmd
+}
+
+func dbSnapshotListCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "list",
+		Short: "Lists the stored snapshots, oldest first.",
+		Run: func(cmd *cobra.Command, args []string) {
+			files, err := database.ListSnapshots(getDataDirFromCmd(cmd))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Println("Snapshots:")
+			fmt.Println("__________________")
+			fmt.Println("")
+			for _, file := range files {
+				status := "ok"
+				if _, err := database.LoadSnapshot(file); err != nil {
+					status = err.Error()
+				}
+
+				fmt.Printf("%d: %s (%s)\n", file.Height, file.Hash.Hex(), status)
+			}
+		},
+	}
+
+	addDefaultRequiredFlags(cmd)
+
+	return cmd
+}
+
+func dbSnapshotPruneCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "prune",
+		Short: "Removes all but the newest snapshots.",
+		Run: func(cmd *cobra.Command, args []string) {
+			keep, _ := cmd.Flags().GetInt(flagKeep)
+
+			pruned, err := database.PruneSnapshots(getDataDirFromCmd(cmd), keep)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			for _, file := range pruned {
+				fmt.Printf("Removed snapshot of block %d '%s'\n", file.Height, file.Hash.Hex())
+			}
+		},
+	}
+
+	addDefaultRequiredFlags(cmd)
+	cmd.Flags().Int(flagKeep, 2, "number of newest snapshots to keep")
+
+	return cmd
+}
+
+func dbVerifyCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "verify",
+		Short: "Re-validates every stored block from genesis and reports the first invalid one.",
+		Run: func(cmd *cobra.Command, args []string) {
+			verified, err := database.VerifyChain(getDataDirFromCmd(cmd))
+			if err != nil {
+				fmt.Printf("Verified %d blocks before failing\n", verified)
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Verified %d blocks, the chain is valid\n", verified)
+		},
+	}
+
+	addDefaultRequiredFlags(cmd)
+
+	return cmd
+}
+
+func dbRollbackCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "rollback",
+		Short: "Reverts the state to a block height, removing every later block. The node must be stopped.",
+		Run: func(cmd *cobra.Command, args []string) {
+			height, _ := cmd.Flags().GetUint64(flagToHeight)
+
+			state, err := database.NewStateFromDisk(getDataDirFromCmd(cmd))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer state.Close()
+
+			removed, err := state.RollbackTo(height)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				// os.Exit skips the deferred Close, which writes the block index and releases the stores
+				state.Close()
+				os.Exit(1)
+			}
+
+			for _, block := range removed {
+				hash, _ := block.Hash()
+				fmt.Printf("Removed block %d '%s'\n", block.Header.Number, hash.Hex())
+			}
+
+			fmt.Printf("Rolled back to block %d '%s'\n", state.LatestBlock().Header.Number, state.LatestBlockHash().Hex())
+		},
+	}
+
+	addDefaultRequiredFlags(cmd)
+	cmd.Flags().Uint64(flagToHeight, 0, "height of the block to become the new tip")
+	cmd.MarkFlagRequired(flagToHeight)
+
+	return cmd
+}
+
+func dbMigrateEncodingCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "migrate-encoding",
+		Short: "Rewrites the blocks stored as JSON in the binary encoding, keeping their hashes. The node must be stopped.",
+		Run: func(cmd *cobra.Command, args []string) {
+			migrated, err := database.MigrateBlockStoreEncoding(getDataDirFromCmd(cmd))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Rewrote %d blocks in the binary encoding\n", migrated)
+		},
+	}
+
+	addDefaultRequiredFlags(cmd)
+
+	return cmd
+}
+
+func dbExportCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "export",
+		Short: "Exports the main chain blocks to a JSONL file which can be imported, or their transactions to a CSV file.",
+		Run: func(cmd *cobra.Command, args []string) {
+			format, _ := cmd.Flags().GetString(flagFormat)
+			path, _ := cmd.Flags().GetString(flagFile)
+			from, _ := cmd.Flags().GetUint64(flagFromHeight)
+
+			to := uint64(math.MaxUint64)
+			if cmd.Flags().Changed(flagToHeight) {
+				to, _ = cmd.Flags().GetUint64(flagToHeight)
+			}
+
+			state, err := database.NewStateFromDisk(getDataDirFromCmd(cmd))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer state.Close()
+
+			f, err := os.Create(path)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				state.Close()
+				os.Exit(1)
+			}
+
+			exported, err := state.ExportBlocks(f, format, from, to)
+			if err == nil {
+				err = f.Close()
+			} else {
+				f.Close()
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				state.Close()
+				os.Exit(1)
+			}
+
+			fmt.Printf("Exported %d blocks to %s\n", exported, path)
+		},
+	}
+
+	addDefaultRequiredFlags(cmd)
+	cmd.Flags().String(flagFile, "", "the file to export to")
+	cmd.MarkFlagRequired(flagFile)
+	cmd.Flags().String(flagFormat, database.ExportFormatJSONL, fmt.Sprintf("the export format, '%s' or '%s'", database.ExportFormatJSONL, database.ExportFormatCSV))
+	cmd.Flags().Uint64(flagFromHeight, 0, "height of the first block to export")
+	cmd.Flags().Uint64(flagToHeight, 0, "height of the last block to export (default the latest block)")
+
+	return cmd
+}
+
+func dbImportCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "import",
+		Short: "Validates and appends the blocks of a JSONL export to the chain, skipping those it has. The node must be stopped.",
+		Run: func(cmd *cobra.Command, args []string) {
+			path, _ := cmd.Flags().GetString(flagFile)
+
+			state, err := database.NewStateFromDisk(getDataDirFromCmd(cmd))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer state.Close()
+
+			f, err := os.Open(path)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				state.Close()
+				os.Exit(1)
+			}
+			defer f.Close()
+
+			imported, skipped, err := state.ImportBlocks(f)
+			if err != nil {
+				fmt.Printf("Imported %d blocks before failing\n", imported)
+				fmt.Fprintln(os.Stderr, err)
+				state.Close()
+				os.Exit(1)
+			}
+
+			fmt.Printf("Imported %d blocks, skipped %d already stored, the tip is block %d '%s'\n", imported, skipped, state.LatestBlock().Header.Number, state.LatestBlockHash().Hex())
+		},
+	}
+
+	addDefaultRequiredFlags(cmd)
+	cmd.Flags().String(flagFile, "", "the JSONL export to import")
+	cmd.MarkFlagRequired(flagFile)
+
+	return cmd
+}
+
+func dbMigrateLegacyCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "migrate-legacy",
+		Short: "Initializes the data dir from the legacy tx.db ledger and old_genesis.json, mapping the named accounts to addresses.",
+		Long: `Initializes the data dir from the legacy tx.db ledger and old_genesis.json.
+
+The mapping file is a JSON object from every legacy account name to its address, such as {"admin": "0x..."}.
+The genesis of the data dir holds the balances of the old genesis and block 0 every legacy transfer, in ledger order.
+Legacy transfers are unsigned, so instead of being mined block 0 is committed to by the genesis, along with the mapping,
+and 'db verify' checks it like the other blocks.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			legacyDir, _ := cmd.Flags().GetString(flagLegacyDir)
+			mappingPath, _ := cmd.Flags().GetString(flagMapping)
+
+			content, err := os.ReadFile(mappingPath)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			var mapping map[string]database.Address
+			if err := json.Unmarshal(content, &mapping); err != nil {
+				fmt.Fprintf(os.Stderr, "invalid mapping file '%s': %s\n", mappingPath, err.Error())
+				os.Exit(1)
+			}
+
+			migration, err := database.MigrateLegacyLedger(legacyDir, mapping, getDataDirFromCmd(cmd))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			genesisHash, err := migration.Genesis.Hash()
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Carried %d legacy transactions forward in block 0 '%s' of genesis '%s' of chain '%s'\n", migration.Replayed, migration.Block.Key.Hex(), genesisHash.Hex(), migration.Genesis.ChainID)
+			fmt.Println("__________________")
+			fmt.Println("")
+
+			names := make([]string, 0, len(mapping))
+			for name := range mapping {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			for _, name := range names {
+				fmt.Printf("%s -> %s: %s\n", name, mapping[name].String(), migration.Balances[mapping[name]])
+			}
+		},
+	}
+
+	addDefaultRequiredFlags(cmd)
+	cmd.Flags().String(flagLegacyDir, "", "the directory holding tx.db and old_genesis.json")
+	cmd.MarkFlagRequired(flagLegacyDir)
+	cmd.Flags().String(flagMapping, "", "the JSON file mapping every legacy account name to an address")
+	cmd.MarkFlagRequired(flagMapping)
+
+	return cmd
+}
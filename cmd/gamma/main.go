@@ -23,6 +23,7 @@ const flagPort = "port"
 const flagBootstrapAcc = "bootstrap-account"
 const flagBootstrapIp = "bootstrap-ip"
 const flagBootstrapPort = "bootstrap-port"
+const flagMaxBlockTimeDrift = "max-block-time-drift"
 
 func main() {
 	var tbbCmd = &cobra.Command{
@@ -36,6 +37,9 @@ func main() {
 	tbbCmd.AddCommand(balancesCmd())
 	tbbCmd.AddCommand(walletCmd())
 	tbbCmd.AddCommand(runCmd())
+	tbbCmd.AddCommand(dbCmd())
+	tbbCmd.AddCommand(accountCmd())
+	tbbCmd.AddCommand(supplyCmd())
 
 	err := tbbCmd.Execute()
 	if err != nil {
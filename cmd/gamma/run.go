@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/jnsoft/gamma/database"
 	"github.com/jnsoft/gamma/node"
@@ -23,6 +25,7 @@ func runCmd() *cobra.Command {
 			bootstrapIp, _ := cmd.Flags().GetString(flagBootstrapIp)
 			bootstrapPort, _ := cmd.Flags().GetUint64(flagBootstrapPort)
 			bootstrapAcc, _ := cmd.Flags().GetString(flagBootstrapAcc)
+			maxBlockTimeDrift, _ := cmd.Flags().GetDuration(flagMaxBlockTimeDrift)
 
 			fmt.Println("Launching TBB node and its HTTP API...")
 
@@ -40,8 +43,14 @@ func runCmd() *cobra.Command {
 			}
 
 			version := fmt.Sprintf("%s.%s.%s-alpha %s %s", Major, Minor, Fix, shortGitCommit(GitCommit), Verbal)
-			n := node.New(getDataDirFromCmd(cmd), ip, port, database.NewAccount(miner), bootstrap, version, node.DefaultMiningDifficulty)
-			err := n.Run(context.Background(), isSSLDisabled, sslEmail)
+			n := node.New(getDataDirFromCmd(cmd), ip, port, database.NewAccount(miner), bootstrap, version)
+			n.SetMaxBlockTimeDrift(maxBlockTimeDrift)
+
+			// the node closes its state once the context is cancelled, persisting the block index
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			err := n.Run(ctx, isSSLDisabled, sslEmail)
 			if err != nil {
 				fmt.Println(err)
 				os.Exit(1)
@@ -58,6 +67,7 @@ func runCmd() *cobra.Command {
 	runCmd.Flags().String(flagBootstrapIp, node.DefaultBootstrapIp, "default bootstrap Web3Coach's server to interconnect peers")
 	runCmd.Flags().Uint64(flagBootstrapPort, node.HttpSSLPort, "default bootstrap Web3Coach's server port to interconnect peers")
 	runCmd.Flags().String(flagBootstrapAcc, node.DefaultBootstrapAcc, "default bootstrap Web3Coach's Genesis account with 1M TBB tokens")
+	runCmd.Flags().Duration(flagMaxBlockTimeDrift, database.DefaultMaxBlockTimeDrift, "how far into the future of your node's clock a block may be stamped once the TIP3 fork is active")
 
 	return runCmd
 }
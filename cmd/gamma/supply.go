@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jnsoft/gamma/database"
+	"github.com/spf13/cobra"
+)
+
+func supplyCmd() *cobra.Command {
+	var cmd = &cobra.Command{
+		Use:   "supply",
+		Short: "Shows the circulating supply and the rewarded, minted and fee totals, at the latest block or at a past one.",
+		Run: func(cmd *cobra.Command, args []string) {
+			state, err := database.NewStateFromDisk(getDataDirFromCmd(cmd))
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			defer state.Close()
+
+			view := state.View()
+			if cmd.Flags().Changed(flagAtHeight) {
+				height, _ := cmd.Flags().GetUint64(flagAtHeight)
+
+				view, err = state.ViewAt(height)
+				if err != nil {
+					fmt.Fprintln(os.Stderr, err)
+					state.Close()
+					os.Exit(1)
+				}
+			}
+
+			supply := view.Supply()
+
+			fmt.Printf("Supply at %x (block %d):\n", view.BlockHash(), view.Height())
+			fmt.Println("__________________")
+			fmt.Println("")
+			fmt.Printf("circulating: %s\n", supply.Circulating)
+			fmt.Printf("genesis: %s\n", supply.Genesis)
+			fmt.Printf("rewarded: %s\n", supply.Rewarded)
+			fmt.Printf("minted: %s\n", supply.Minted)
+			fmt.Printf("fees: %s\n", supply.Fees)
+		},
+	}
+
+	addDefaultRequiredFlags(cmd)
+	cmd.Flags().Uint64(flagAtHeight, 0, "height of the block to show the supply after, the latest block by default")
+
+	return cmd
+}
@@ -6,7 +6,7 @@ import (
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/ethereum/go-ethereum/accounts/keystore"
-	"github.com/ethereum/go-ethereum/cmd/utils"
+	"github.com/ethereum/go-ethereum/console/prompt"
 	"github.com/jnsoft/gamma/wallet"
 	"github.com/spf13/cobra"
 )
@@ -81,6 +81,29 @@ func walletPrintPrivKeyCmd() *cobra.Command {
 	return cmd
 }
 
-func getPassPhrase(prompt string, confirmation bool) string {
-	return utils.GetPassPhrase(prompt, confirmation)
+func getPassPhrase(text string, confirmation bool) string {
+	if text != "" {
+		fmt.Println(text)
+	}
+
+	password, err := prompt.Stdin.PromptPassword("Password: ")
+	if err != nil {
+		fmt.Printf("Failed to read password: %v\n", err)
+		os.Exit(1)
+	}
+
+	if confirmation {
+		confirm, err := prompt.Stdin.PromptPassword("Repeat password: ")
+		if err != nil {
+			fmt.Printf("Failed to read password confirmation: %v\n", err)
+			os.Exit(1)
+		}
+
+		if password != confirm {
+			fmt.Println("Passwords do not match")
+			os.Exit(1)
+		}
+	}
+
+	return password
 }
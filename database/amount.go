@@ -0,0 +1,133 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/holiman/uint256"
+)
+
+// Amount is a quantity of tokens: a balance, a tx value, a gas price or a reward.
+//
+// It holds up to 256 bits and its arithmetic is checked, an operation whose result doesn't fit
+// or would be negative fails instead of wrapping around. Amounts are JSON numbers like the native
+// integers they replaced, so the JSON of existing txs, blocks and genesis files is unchanged.
+type Amount struct {
+	v uint256.Int
+}
+
+func NewAmount(value uint64) Amount {
+	var a Amount
+	a.v.SetUint64(value)
+
+	return a
+}
+
+// ParseAmount parses a decimal amount.
+func ParseAmount(value string) (Amount, error) {
+	var a Amount
+
+	if err := a.v.SetFromDecimal(value); err != nil {
+		return Amount{}, fmt.Errorf("invalid amount '%s': %s", value, err.Error())
+	}
+
+	return a, nil
+}
+
+func amountFromUint256(value *uint256.Int) Amount {
+	var a Amount
+	if value != nil {
+		a.v.Set(value)
+	}
+
+	return a
+}
+
+func (a Amount) Add(b Amount) (Amount, error) {
+	var sum Amount
+	if _, overflow := sum.v.AddOverflow(&a.v, &b.v); overflow {
+		return Amount{}, fmt.Errorf("amount overflow: %s + %s", a, b)
+	}
+
+	return sum, nil
+}
+
+func (a Amount) Sub(b Amount) (Amount, error) {
+	var diff Amount
+	if _, underflow := diff.v.SubOverflow(&a.v, &b.v); underflow {
+		return Amount{}, fmt.Errorf("amount underflow: %s - %s", a, b)
+	}
+
+	return diff, nil
+}
+
+func (a Amount) Mul(b Amount) (Amount, error) {
+	var product Amount
+	if _, overflow := product.v.MulOverflow(&a.v, &b.v); overflow {
+		return Amount{}, fmt.Errorf("amount overflow: %s * %s", a, b)
+	}
+
+	return product, nil
+}
+
+// Cmp returns -1, 0 or 1 if the amount is lower than, equal to or greater than b.
+func (a Amount) Cmp(b Amount) int {
+	return a.v.Cmp(&b.v)
+}
+
+func (a Amount) IsZero() bool {
+	return a.v.IsZero()
+}
+
+func (a Amount) IsUint64() bool {
+	return a.v.IsUint64()
+}
+
+func (a Amount) Uint64() uint64 {
+	return a.v.Uint64()
+}
+
+func (a Amount) ToBig() *big.Int {
+	return a.v.ToBig()
+}
+
+func (a Amount) uint256() *uint256.Int {
+	return new(uint256.Int).Set(&a.v)
+}
+
+// Bytes32 returns the amount as 32 big endian bytes.
+func (a Amount) Bytes32() [32]byte {
+	return a.v.Bytes32()
+}
+
+func (a Amount) String() string {
+	return a.v.Dec()
+}
+
+// MarshalJSON encodes the amount as a JSON number.
+func (a Amount) MarshalJSON() ([]byte, error) {
+	return []byte(a.v.Dec()), nil
+}
+
+// UnmarshalJSON accepts a JSON number or a decimal string, for clients which can't represent large numbers.
+func (a *Amount) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		var value string
+		if err := json.Unmarshal(data, &value); err != nil {
+			return err
+		}
+
+		data = []byte(value)
+	}
+
+	parsed, err := ParseAmount(string(data))
+	if err != nil {
+		return err
+	}
+
+	*a = parsed
+
+	return nil
+}
@@ -0,0 +1,94 @@
+package database
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+const maxAmount = "115792089237316195423570985008687907853269984665640564039457584007913129639935"
+
+func TestAmount(t *testing.T) {
+	max, err := ParseAmount(maxAmount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := max.Add(NewAmount(1)); err == nil {
+		t.Fatal("adding to the largest amount should overflow")
+	}
+
+	if _, err := NewAmount(1).Sub(NewAmount(2)); err == nil {
+		t.Fatal("subtracting a larger amount should fail")
+	}
+
+	if _, err := max.Mul(NewAmount(2)); err == nil {
+		t.Fatal("doubling the largest amount should overflow")
+	}
+
+	// amounts are JSON numbers, decimal strings are accepted for clients which can't represent large ones
+	encoded, err := json.Marshal(map[string]Amount{"value": max})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(encoded) != `{"value":`+maxAmount+`}` {
+		t.Fatalf("amount should be encoded as a JSON number, got %s", encoded)
+	}
+
+	var decoded map[string]Amount
+	if err := json.Unmarshal([]byte(`{"number":`+maxAmount+`,"string":"`+maxAmount+`"}`), &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if decoded["number"] != max || decoded["string"] != max {
+		t.Fatalf("amounts should decode to %s, got %s and %s", max, decoded["number"], decoded["string"])
+	}
+
+	if err := json.Unmarshal([]byte(`{"value":-1}`), &decoded); err == nil {
+		t.Fatal("negative amounts should be rejected")
+	}
+}
+
+func TestApplyTxRejectsOverflow(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+
+	max, err := ParseAmount(maxAmount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the supply never exceeds the largest amount, so no balance can overflow once the genesis is loaded
+	overflowing := Genesis{Balances: map[Address]Amount{andrej.addr: NewAmount(1000), babaYaga.addr: max}}
+	if _, err := overflowing.Supply(); err == nil {
+		t.Fatal("genesis balances overflowing the supply should be rejected")
+	}
+
+	state, _ := newTestState(t, map[Address]Amount{andrej.addr: NewAmount(1000)})
+
+	tx := NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(10), 1, "")
+	tx.GasPrice = max
+	if err := ApplyTx(andrej.sign(t, tx), state); err == nil {
+		t.Fatal("tx whose gas cost overflows should be rejected")
+	}
+
+	if state.Balances[andrej.addr] != NewAmount(1000) || state.Account2Nonce[andrej.addr] != 0 {
+		t.Fatalf("rejected tx must not change the sender, andrej has %s and nonce %d", state.Balances[andrej.addr], state.Account2Nonce[andrej.addr])
+	}
+
+	// a balance larger than 64 bits still has a provable state
+	largest, _ := max.Sub(NewAmount(BlockReward))
+	proofState, _ := newTestState(t, map[Address]Amount{babaYaga.addr: largest})
+	if _, err := proofState.AddBlock(mineTestBlock(t, proofState, andrej.addr, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	proof, err := proofState.GetAccountProof(babaYaga.addr, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if proof.Balance != largest || !proof.Verify() {
+		t.Fatalf("proof of the largest balance should be valid, got %s", proof.Balance)
+	}
+}
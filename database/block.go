@@ -4,18 +4,12 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
-
-	"github.com/jnsoft/gamma/util/misc"
-	"github.com/jnsoft/gamma/util/security"
+	"math/big"
 )
 
+// BlockReward is the miner reward of chains whose genesis doesn't set one.
 const BlockReward = 100
 
-type SimpleBlock struct {
-	Header BlockHeader `json:"header"`
-	TXs    []SimpleTx  `json:"payload"` // new transactions only (payload)
-}
-
 type Block struct {
 	Header BlockHeader `json:"header"`  // metadata (parent block hash + time)
 	TXs    []SignedTx  `json:"payload"` // new transactions only (payload)
@@ -27,6 +21,12 @@ type BlockHeader struct {
 	Nonce  uint32  `json:"nonce"`
 	Time   uint64  `json:"time"`
 	Miner  Address `json:"miner"`
+	TxRoot Hash    `json:"tx_root"` // Merkle root of the payload, empty for blocks mined before it was introduced
+
+	// StateRoot is the Merkle root of the accounts after the block is applied, empty for blocks not committing to it
+	StateRoot Hash `json:"state_root"`
+
+	Version uint8 `json:"version"` // encoding the header is hashed over
 }
 
 type BlockFS struct {
@@ -34,20 +34,22 @@ type BlockFS struct {
 	Value Block `json:"block"`
 }
 
-type SimpleBlockFS struct {
-	Key   Hash        `json:"hash"`
-	Value SimpleBlock `json:"block"`
-}
+func NewBlock(parent Hash, number uint64, nonce uint32, time uint64, miner Address, txs []SignedTx) Block {
+	txRoot, _ := TxMerkleRoot(txs)
 
-func NewSimpleBlock(parent Hash, number uint64, miner Address, txs []SimpleTx) SimpleBlock {
-	return SimpleBlock{BlockHeader{parent, number, security.GenerateNonce(), misc.GetTime(), miner}, txs}
-}
-
-func NewBlock(parent Hash, number uint64, miner Address, txs []SignedTx) Block {
-	return Block{BlockHeader{parent, number, security.GenerateNonce(), misc.GetTime(), miner}, txs}
+	return Block{BlockHeader{parent, number, nonce, time, miner, txRoot, Hash{}, CurrentEncoding}, txs}
 }
 
+// Hash of a block committing to its payload with a tx root covers only the header,
+// so the header alone is enough to check a transaction inclusion proof.
+//
+// Blocks mined before the tx root was introduced are hashed with their whole payload. A binary header is always
+// hashed alone, so it's only valid along with a tx root.
 func (b Block) Hash() (Hash, error) {
+	if b.Header.Version != EncodingLegacyJSON || !b.Header.TxRoot.IsEmpty() {
+		return b.Header.Hash()
+	}
+
 	blockJson, err := json.Marshal(b)
 	if err != nil {
 		return Hash{}, err
@@ -56,33 +58,115 @@ func (b Block) Hash() (Hash, error) {
 	return sha256.Sum256(blockJson), nil
 }
 
-func (b SimpleBlock) Hash() (Hash, error) {
-	blockJson, err := json.Marshal(b)
+func (h BlockHeader) Hash() (Hash, error) {
+	encoded, err := h.Encode()
 	if err != nil {
 		return Hash{}, err
 	}
 
-	return sha256.Sum256(blockJson), nil
+	return sha256.Sum256(encoded), nil
 }
 
-func (b Block) GasReward() uint {
-	reward := uint(0)
+// Encode returns the encoding the header is hashed over, depending on its version.
+func (h BlockHeader) Encode() ([]byte, error) {
+	if h.Version == EncodingLegacyJSON {
+		return json.Marshal(h)
+	}
 
-	for _, tx := range b.TXs {
-		reward += tx.GasCost()
+	return encodeVersioned(h.Version, h.toRLP())
+}
+
+// MarshalJSON leaves out the tx and state roots of legacy blocks mined before they were introduced, keeping their hashes unchanged.
+// Since the canonical encoding, it is only used by the HTTP API.
+func (h BlockHeader) MarshalJSON() ([]byte, error) {
+	if h.Version != EncodingLegacyJSON {
+		type versionedHeader struct {
+			Parent    Hash    `json:"parent"`
+			Number    uint64  `json:"number"`
+			Nonce     uint32  `json:"nonce"`
+			Time      uint64  `json:"time"`
+			Miner     Address `json:"miner"`
+			TxRoot    Hash    `json:"tx_root"`
+			StateRoot Hash    `json:"state_root"`
+			Version   uint8   `json:"version"`
+		}
+
+		return json.Marshal(versionedHeader{h.Parent, h.Number, h.Nonce, h.Time, h.Miner, h.TxRoot, h.StateRoot, h.Version})
+	}
+
+	type legacyHeader struct {
+		Parent Hash    `json:"parent"`
+		Number uint64  `json:"number"`
+		Nonce  uint32  `json:"nonce"`
+		Time   uint64  `json:"time"`
+		Miner  Address `json:"miner"`
+	}
+
+	if h.TxRoot.IsEmpty() && h.StateRoot.IsEmpty() {
+		return json.Marshal(legacyHeader{h.Parent, h.Number, h.Nonce, h.Time, h.Miner})
 	}
 
-	return reward
+	type txRootHeader struct {
+		Parent Hash    `json:"parent"`
+		Number uint64  `json:"number"`
+		Nonce  uint32  `json:"nonce"`
+		Time   uint64  `json:"time"`
+		Miner  Address `json:"miner"`
+		TxRoot Hash    `json:"tx_root"`
+	}
+
+	if h.StateRoot.IsEmpty() {
+		return json.Marshal(txRootHeader{h.Parent, h.Number, h.Nonce, h.Time, h.Miner, h.TxRoot})
+	}
+
+	type header struct {
+		Parent    Hash    `json:"parent"`
+		Number    uint64  `json:"number"`
+		Nonce     uint32  `json:"nonce"`
+		Time      uint64  `json:"time"`
+		Miner     Address `json:"miner"`
+		TxRoot    Hash    `json:"tx_root"`
+		StateRoot Hash    `json:"state_root"`
+	}
+
+	return json.Marshal(header{h.Parent, h.Number, h.Nonce, h.Time, h.Miner, h.TxRoot, h.StateRoot})
+}
+
+// MinerReward is what the miner of the block is paid, the block reward and the fees of its transactions.
+func (b Block) MinerReward(rules Rules) (Amount, error) {
+	if !rules.IsTIP1 {
+		fees, err := NewAmount(uint64(TxFee)).Mul(NewAmount(uint64(len(b.TXs))))
+		if err != nil {
+			return Amount{}, err
+		}
+
+		return rules.BlockReward.Add(fees)
+	}
+
+	gasReward, err := b.GasReward()
+	if err != nil {
+		return Amount{}, err
+	}
+
+	return rules.BlockReward.Add(gasReward)
 }
 
-func (b SimpleBlock) GasReward() uint {
-	reward := uint(0)
+func (b Block) GasReward() (Amount, error) {
+	var reward Amount
 
 	for _, tx := range b.TXs {
-		reward += tx.GasCost()
+		gasCost, err := tx.GasCost()
+		if err != nil {
+			return Amount{}, err
+		}
+
+		reward, err = reward.Add(gasCost)
+		if err != nil {
+			return Amount{}, err
+		}
 	}
 
-	return reward
+	return reward, nil
 }
 
 func IsBlockHashValid(hash Hash, miningDifficulty uint) bool {
@@ -100,3 +184,15 @@ func IsBlockHashValid(hash Hash, miningDifficulty uint) bool {
 
 	return zeroesCount == miningDifficulty
 }
+
+// blockWork is the expected number of hashes mining the block took, 256 to the power of the leading zero bytes
+// of its hash. A valid hash has exactly as many as the difficulty the block was mined at, so the work of a block
+// is known from its hash, even once the mining difficulty changed.
+func blockWork(hash Hash) *big.Int {
+	zeroes := 0
+	for zeroes < len(hash) && hash[zeroes] == 0 {
+		zeroes++
+	}
+
+	return new(big.Int).Lsh(big.NewInt(1), uint(8*zeroes))
+}
@@ -1,89 +1,144 @@
 package database
 
 import (
-	"bufio"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
-	"reflect"
 )
 
-func GetBlocksAfter(blockHash Hash, dataDir string) ([]Block, error) {
-	f, err := os.OpenFile(getBlocksDbFilePath(dataDir), os.O_RDONLY, 0600)
+// TxProof proves a transaction is included in the block with the given header.
+type TxProof struct {
+	Tx        SignedTx     `json:"tx"`
+	BlockHash Hash         `json:"block_hash"`
+	Header    BlockHeader  `json:"block_header"`
+	Index     int          `json:"index"`
+	Proof     []MerkleNode `json:"proof"`
+}
+
+// Verify checks the proof against the tx root of the header.
+func (p TxProof) Verify() (bool, error) {
+	leaf, err := p.Tx.signedHash()
 	if err != nil {
-		return nil, err
+		return false, err
 	}
 
-	blocks := make([]Block, 0)
-	shouldStartCollecting := false
+	return VerifyMerkleProof(p.Header.TxRoot, leaf, p.Proof), nil
+}
+
+// GetBlocksAfter returns every block of the chain after the block with the given hash.
+func (s *State) GetBlocksAfter(blockHash Hash) ([]Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	if reflect.DeepEqual(blockHash, Hash{}) {
-		shouldStartCollecting = true
+	return s.store.BlocksAfter(blockHash)
+}
+
+// errPageFull stops a ForEach once a page of blocks is complete.
+var errPageFull = errors.New("page full")
+
+// ForEachBlockAfter calls fn for at most limit blocks of the chain after the block with the given hash, in height order,
+// an empty hash starting from the first block. It returns whether more blocks follow the page, and no blocks if the
+// hash isn't part of the chain.
+//
+// The page is read into memory under the state lock, so a concurrent reorg or rollback can't mix branches within it.
+// The lock is released before fn runs though, so a slow fn, such as writing to a peer, doesn't hold back new blocks.
+func (s *State) ForEachBlockAfter(blockHash Hash, limit int, fn func(BlockFS) error) (bool, error) {
+	page, more, err := s.blockPageAfter(blockHash, limit)
+	if err != nil {
+		return false, err
 	}
 
-	scanner := bufio.NewScanner(f)
-	for scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return nil, err
+	for _, blockFs := range page {
+		if err := fn(blockFs); err != nil {
+			return false, err
 		}
+	}
 
-		var blockFs BlockFS
-		err = json.Unmarshal(scanner.Bytes(), &blockFs)
+	return more, nil
+}
+
+// blockPageAfter returns at most limit blocks of the chain after the block with the given hash and whether more follow.
+func (s *State) blockPageAfter(blockHash Hash, limit int) ([]BlockFS, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	from := uint64(0)
+	if !blockHash.IsEmpty() {
+		blockFs, err := s.store.BlockByHash(blockHash)
+		if errors.Is(err, errBlockNotFound) {
+			return nil, false, nil
+		}
 		if err != nil {
-			return nil, err
+			return nil, false, err
 		}
 
-		if shouldStartCollecting {
-			blocks = append(blocks, blockFs.Value)
-			continue
-		}
+		from = blockFs.Value.Header.Number + 1
+	}
 
-		if blockHash == blockFs.Key {
-			shouldStartCollecting = true
+	page := make([]BlockFS, 0)
+	more := false
+
+	err := s.store.ForEach(from, func(blockFs BlockFS) error {
+		if len(page) == limit {
+			more = true
+			return errPageFull
 		}
+
+		page = append(page, blockFs)
+
+		return nil
+	})
+	if err != nil && err != errPageFull {
+		return nil, false, err
 	}
 
-	return blocks, nil
+	return page, more, nil
 }
 
 // GetBlockByHeightOrHash returns the requested block by hash or height.
-// It uses cached data in the State struct (HashCache / HeightCache)
-func GetBlockByHeightOrHash(state *State, height uint64, hash, dataDir string) (BlockFS, error) {
+// The lookup is answered by the block store index, without scanning the chain.
+func (s *State) GetBlockByHeightOrHash(height uint64, hash string) (BlockFS, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if hash == "" {
+		return s.store.BlockByHeight(height)
+	}
+
+	var h Hash
+	if len(hash) != 2*HashLength || h.UnmarshalText([]byte(hash)) != nil {
+		return BlockFS{}, fmt.Errorf("invalid hash: '%v'", hash)
+	}
+
+	return s.store.BlockByHash(h)
+}
 
-	var block BlockFS
+// GetTxProof returns the Merkle proof of the transaction with the given hash being included in its block.
+func (s *State) GetTxProof(txHash Hash) (TxProof, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 
-	key, ok := state.HeightCache[height]
-	if hash != "" {
-		key, ok = state.HashCache[hash]
+	blockFs, index, ok, err := s.findTx(txHash)
+	if err != nil {
+		return TxProof{}, err
 	}
 
 	if !ok {
-		if hash != "" {
-			return block, fmt.Errorf("invalid hash: '%v'", hash)
-		}
-		return block, fmt.Errorf("invalid height: '%v'", height)
+		return TxProof{}, fmt.Errorf("tx '%s' is not part of the chain", txHash.Hex())
 	}
 
-	f, err := os.OpenFile(getBlocksDbFilePath(dataDir), os.O_RDONLY, 0600)
-	if err != nil {
-		return block, err
+	if blockFs.Value.Header.TxRoot.IsEmpty() {
+		return TxProof{}, fmt.Errorf("block %d was mined before blocks committed to a tx root", blockFs.Value.Header.Number)
 	}
-	defer f.Close()
 
-	_, err = f.Seek(key, 0)
+	leaves, err := txLeaves(blockFs.Value.TXs)
 	if err != nil {
-		return block, err
+		return TxProof{}, err
 	}
-	scanner := bufio.NewScanner(f)
-	if scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return block, err
-		}
-		err = json.Unmarshal(scanner.Bytes(), &block)
-		if err != nil {
-			return block, err
-		}
+
+	proof, err := MerkleProof(leaves, index)
+	if err != nil {
+		return TxProof{}, err
 	}
 
-	return block, nil
+	return TxProof{blockFs.Value.TXs[index], blockFs.Key, blockFs.Value.Header, index, proof}, nil
 }
@@ -0,0 +1,232 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/rlp"
+	"github.com/holiman/uint256"
+)
+
+// Encoding versions of transactions and block headers.
+//
+// The version tells which encoding a tx is signed and hashed over and which encoding a header is hashed over.
+// Stored and synced objects carry their version, so objects created before the canonical encoding
+// keep their original hashes. Once TIP4 is active, new blocks and transactions must use the binary encoding.
+const (
+	// EncodingLegacyJSON hashes and signs the JSON encoding, which depends on field order and escaping.
+	EncodingLegacyJSON = uint8(0)
+
+	// EncodingRLPv1 hashes and signs the version byte followed by the RLP encoding of the fields.
+	EncodingRLPv1 = uint8(1)
+
+	// CurrentEncoding is the version of newly created transactions and blocks.
+	CurrentEncoding = EncodingRLPv1
+)
+
+// rlpTx lists the fields of a tx in their canonical order, independently of the Go struct and its JSON tags.
+type rlpTx struct {
+	From     Address
+	To       Address
+	Gas      uint64
+	GasPrice *uint256.Int
+	Value    *uint256.Int
+	Nonce    uint64
+	Data     string
+	Time     uint64
+
+	// ChainID is left out of the encoding when empty, so txs signed before it was introduced keep their hashes
+	ChainID string `rlp:"optional"`
+}
+
+type rlpSignedTx struct {
+	Version uint8
+	Tx      rlpTx
+	Sig     []byte
+}
+
+type rlpHeader struct {
+	Parent    Hash
+	Number    uint64
+	Nonce     uint32
+	Time      uint64
+	Miner     Address
+	TxRoot    Hash
+	StateRoot Hash
+}
+
+type rlpBlock struct {
+	Header rlpHeader
+	TXs    []rlpSignedTx
+}
+
+func checkEncodingVersion(version uint8) error {
+	if version > CurrentEncoding {
+		return fmt.Errorf("unsupported encoding version %d", version)
+	}
+
+	return nil
+}
+
+// checkBlockEncoding enforces, from TIP4 on, that the block header is hashed over the binary encoding
+// and commits to its payload with a tx root and to the resulting state with a state root. Its transactions
+// are checked along with the other tx rules.
+func (s *State) checkBlockEncoding(b Block) error {
+	if !s.rules().IsTIP4 {
+		return nil
+	}
+
+	if b.Header.Version == EncodingLegacyJSON {
+		return fmt.Errorf("block must use encoding version %d since TIP4 fork is active, not %d", EncodingRLPv1, b.Header.Version)
+	}
+
+	if b.Header.TxRoot.IsEmpty() {
+		return fmt.Errorf("block must commit to its txs with a tx root since TIP4 fork is active")
+	}
+
+	if b.Header.StateRoot.IsEmpty() {
+		return fmt.Errorf("block must commit to the resulting state with a state root since TIP4 fork is active")
+	}
+
+	return nil
+}
+
+// encodeVersioned returns the version byte followed by the RLP encoding of the value.
+func encodeVersioned(version uint8, value interface{}) ([]byte, error) {
+	if err := checkEncodingVersion(version); err != nil {
+		return nil, err
+	}
+
+	encoded, err := rlp.EncodeToBytes(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte{version}, encoded...), nil
+}
+
+// decodeVersioned decodes the RLP encoding following the version byte into the value and returns the version.
+func decodeVersioned(encoded []byte, value interface{}) (uint8, error) {
+	if len(encoded) == 0 {
+		return 0, fmt.Errorf("empty encoding")
+	}
+
+	if err := checkEncodingVersion(encoded[0]); err != nil {
+		return 0, err
+	}
+
+	return encoded[0], rlp.DecodeBytes(encoded[1:], value)
+}
+
+func (t Tx) toRLP() rlpTx {
+	return rlpTx{t.From, t.To, uint64(t.Gas), t.GasPrice.uint256(), t.Value.uint256(), uint64(t.Nonce), t.Data, t.Time, t.ChainID}
+}
+
+func (t rlpTx) toTx(version uint8) Tx {
+	return Tx{t.From, t.To, uint(t.Gas), amountFromUint256(t.GasPrice), amountFromUint256(t.Value), uint(t.Nonce), t.Data, t.Time, t.ChainID, version}
+}
+
+func (h BlockHeader) toRLP() rlpHeader {
+	return rlpHeader{h.Parent, h.Number, h.Nonce, h.Time, h.Miner, h.TxRoot, h.StateRoot}
+}
+
+func (h rlpHeader) toHeader(version uint8) BlockHeader {
+	return BlockHeader{h.Parent, h.Number, h.Nonce, h.Time, h.Miner, h.TxRoot, h.StateRoot, version}
+}
+
+// EncodeBlock returns the binary encoding a block is stored in, whatever encoding its hash is computed over.
+func EncodeBlock(b Block) ([]byte, error) {
+	txs := make([]rlpSignedTx, len(b.TXs))
+	for i, tx := range b.TXs {
+		if err := checkEncodingVersion(tx.Version); err != nil {
+			return nil, err
+		}
+
+		txs[i] = rlpSignedTx{tx.Version, tx.toRLP(), tx.Sig}
+	}
+
+	return encodeVersioned(b.Header.Version, rlpBlock{b.Header.toRLP(), txs})
+}
+
+// DecodeBlock parses the binary encoding of a block.
+func DecodeBlock(encoded []byte) (Block, error) {
+	var raw rlpBlock
+
+	version, err := decodeVersioned(encoded, &raw)
+	if err != nil {
+		return Block{}, err
+	}
+
+	txs := make([]SignedTx, len(raw.TXs))
+	for i, tx := range raw.TXs {
+		if err := checkEncodingVersion(tx.Version); err != nil {
+			return Block{}, err
+		}
+
+		sig := tx.Sig
+		if len(sig) == 0 {
+			sig = nil
+		}
+
+		txs[i] = SignedTx{tx.Tx.toTx(tx.Version), sig}
+	}
+
+	return Block{raw.Header.toHeader(version), txs}, nil
+}
+
+// encodeBlockFS returns the binary encoding of the block followed by its hash.
+//
+// The encoding starts with the version byte, which tells it apart from the JSON stored before it was introduced.
+// Legacy blocks hashed with their whole payload are kept as JSON if their hash depends on details the binary
+// encoding doesn't carry, such as a null payload instead of an empty one.
+func encodeBlockFS(blockFs BlockFS) ([]byte, error) {
+	encoded, err := EncodeBlock(blockFs.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	if blockFs.Value.Header.Version == EncodingLegacyJSON && blockFs.Value.Header.TxRoot.IsEmpty() {
+		decoded, err := DecodeBlock(encoded)
+		if err != nil {
+			return nil, err
+		}
+
+		hash, err := decoded.Hash()
+		if err != nil {
+			return nil, err
+		}
+
+		if hash != blockFs.Key {
+			return json.Marshal(blockFs)
+		}
+	}
+
+	return append(encoded, blockFs.Key[:]...), nil
+}
+
+// decodeBlockFS parses a stored block, either binary or the JSON stored before the canonical encoding.
+func decodeBlockFS(encoded []byte) (BlockFS, error) {
+	var blockFs BlockFS
+
+	if isJSONRecord(encoded) {
+		err := json.Unmarshal(encoded, &blockFs)
+		return blockFs, err
+	}
+
+	if len(encoded) < HashLength {
+		return blockFs, fmt.Errorf("stored block is too short")
+	}
+
+	split := len(encoded) - HashLength
+	b, err := DecodeBlock(encoded[:split])
+	if err != nil {
+		return blockFs, err
+	}
+
+	return BlockFS{Hash(encoded[split:]), b}, nil
+}
+
+func isJSONRecord(encoded []byte) bool {
+	return bytes.HasPrefix(encoded, []byte("{"))
+}
@@ -0,0 +1,231 @@
+package database
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/jnsoft/gamma/util/security"
+)
+
+// mineLegacyTestBlock mines a block the way it was before tx roots and the binary encoding were introduced,
+// hashed with the JSON of its whole payload.
+func mineLegacyTestBlock(t *testing.T, s *State, miner Address, txs []SignedTx) Block {
+	b := Block{BlockHeader{Parent: s.LatestBlockHash(), Number: s.NextBlockNumber(), Time: uint64(len(txs)), Miner: miner}, txs}
+
+	for {
+		b.Header.Nonce = security.GenerateNonce()
+
+		hash, err := b.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if IsBlockHashValid(hash, testMiningDifficulty) {
+			return b
+		}
+	}
+}
+
+func legacyTestTx(t *testing.T, from testAccount, to Address, value Amount, nonce uint) SignedTx {
+	tx := NewBaseTx(from.addr, to, value, nonce, "<legacy & escaped>")
+	tx.Version = EncodingLegacyJSON
+
+	return from.sign(t, tx)
+}
+
+func TestEncodeBlock(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+
+	state, _ := newTestState(t, map[Address]Amount{andrej.addr: NewAmount(1000)})
+
+	tx := andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(10), 1, "data"))
+	legacyTx := legacyTestTx(t, andrej, babaYaga.addr, NewAmount(10), 2)
+
+	blocks := []Block{
+		mineTestBlock(t, state, babaYaga.addr, []SignedTx{tx, legacyTx}),
+		mineLegacyTestBlock(t, state, babaYaga.addr, []SignedTx{legacyTx}),
+		mineLegacyTestBlock(t, state, babaYaga.addr, nil),
+	}
+
+	for i, b := range blocks {
+		hash, err := b.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		encoded, err := encodeBlockFS(BlockFS{hash, b})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// a null payload can't be told apart from an empty one in the binary encoding, the block is kept as JSON
+		if isJSONRecord(encoded) != (b.TXs == nil) {
+			t.Fatalf("block %d: stored as JSON should be %t", i, b.TXs == nil)
+		}
+
+		decoded, err := decodeBlockFS(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(decoded.Value, b) {
+			t.Fatalf("block %d: decoded block differs from the encoded one", i)
+		}
+
+		decodedHash, err := decoded.Value.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if decoded.Key != hash || decodedHash != hash {
+			t.Fatalf("block %d: hash should stay %s, not %s", i, hash.Hex(), decodedHash.Hex())
+		}
+
+		for _, tx := range decoded.Value.TXs {
+			if ok, err := tx.IsAuthentic(); err != nil || !ok {
+				t.Fatalf("block %d: decoded tx should stay authentic", i)
+			}
+		}
+	}
+
+	// the hash of a versioned tx doesn't depend on its JSON
+	encodedTx, err := tx.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if encodedTx[0] != EncodingRLPv1 || json.Valid(encodedTx) {
+		t.Fatalf("versioned tx should be encoded as RLP, got %q", encodedTx)
+	}
+
+	tx.Version = CurrentEncoding + 1
+	if _, err := tx.Hash(); err == nil {
+		t.Fatal("hashing a tx of an unknown encoding version should fail")
+	}
+}
+
+func TestMigrateBlockStoreEncoding(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+
+	state, dataDir := newTestState(t, map[Address]Amount{andrej.addr: NewAmount(1000)})
+
+	var legacyJsonl []byte
+	for i := uint(1); i <= 3; i++ {
+		b := mineLegacyTestBlock(t, state, babaYaga.addr, []SignedTx{legacyTestTx(t, andrej, babaYaga.addr, NewAmount(10), i)})
+
+		hash, err := state.AddBlock(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		blockFsJson, err := json.Marshal(BlockFS{hash, b})
+		if err != nil {
+			t.Fatal(err)
+		}
+		legacyJsonl = append(append(legacyJsonl, blockFsJson...), '\n')
+	}
+	tip := state.LatestBlockHash()
+	state.Close()
+
+	// a block.db written before the binary encoding
+	if err := os.WriteFile(getBlocksDbFilePath(dataDir), legacyJsonl, 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(getBlocksIndexFilePath(dataDir))
+
+	migrated, err := MigrateBlockStoreEncoding(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if migrated != 3 {
+		t.Fatalf("3 blocks should have been migrated, not %d", migrated)
+	}
+
+	content, err := os.ReadFile(getBlocksDbFilePath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(content) == 0 || content[0] != binaryRecordMarker || len(content) >= len(legacyJsonl) {
+		t.Fatalf("block.db should have been rewritten with smaller binary records, %d bytes instead of %d", len(content), len(legacyJsonl))
+	}
+
+	backup, err := os.ReadFile(getBlocksDbFilePath(dataDir) + ".bak")
+	if err != nil || !bytes.Equal(backup, legacyJsonl) {
+		t.Fatal("the previous block.db should have been kept")
+	}
+
+	if fileExist(getBlocksDbFilePath(dataDir)+".tmp") || fileExist(getBlocksDbFilePath(dataDir)+".tmp.idx") {
+		t.Fatal("the temp block.db should be moved in place after the migration")
+	}
+
+	migratedState, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer migratedState.Close()
+
+	if migratedState.LatestBlockHash() != tip {
+		t.Fatalf("latest block hash should stay %s, not %s", tip.Hex(), migratedState.LatestBlockHash().Hex())
+	}
+
+	if migratedState.Balances[babaYaga.addr] != NewAmount(30+3*BlockReward+3*TxGas*TxGasPriceDefault) {
+		t.Fatalf("baba yaga balance should be unchanged after the migration, got %s", migratedState.Balances[babaYaga.addr])
+	}
+}
+
+func TestEncodingFork(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+
+	state, _ := newTestStateFromGenesis(t, Genesis{ChainID: "gamma-test", Symbol: "TGL", Balances: map[Address]Amount{andrej.addr: NewAmount(1000)}, Difficulty: testMiningDifficulty, BlockReward: NewAmount(BlockReward), Forks: Forks{ForkTIP1: 0, ForkTIP4: 2}})
+
+	// blocks and txs of the legacy encoding stay valid before the fork
+	for i := uint(1); i <= 2; i++ {
+		b := mineLegacyTestBlock(t, state, babaYaga.addr, []SignedTx{legacyTestTx(t, andrej, babaYaga.addr, NewAmount(10), i)})
+		if _, err := state.AddBlock(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := state.AddBlock(mineLegacyTestBlock(t, state, babaYaga.addr, []SignedTx{})); err == nil {
+		t.Fatal("block of the legacy encoding should be rejected once TIP4 is active")
+	}
+
+	legacyTx := legacyTestTx(t, andrej, babaYaga.addr, NewAmount(10), 3)
+	if err := ValidateTx(legacyTx, state); err == nil {
+		t.Fatal("tx of the legacy encoding should be rejected once TIP4 is active")
+	}
+
+	if _, err := state.AddBlock(mineTestBlock(t, state, babaYaga.addr, []SignedTx{legacyTx})); err == nil {
+		t.Fatal("block including a tx of the legacy encoding should be rejected once TIP4 is active")
+	}
+
+	tx := andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(10), 3, ""))
+
+	uncommitted := mineTestBlock(t, state, babaYaga.addr, []SignedTx{tx})
+	uncommitted.Header.TxRoot = Hash{}
+	uncommitted = sealTestBlock(t, uncommitted)
+
+	if _, err := state.AddBlock(uncommitted); err == nil {
+		t.Fatal("block without a tx root should be rejected once TIP4 is active")
+	}
+
+	stateless := mineTestBlock(t, state, babaYaga.addr, []SignedTx{tx})
+	stateless.Header.StateRoot = Hash{}
+	stateless = sealTestBlock(t, stateless)
+
+	if _, err := state.AddBlock(stateless); err == nil {
+		t.Fatal("block without a state root should be rejected once TIP4 is active")
+	}
+
+	if _, err := state.AddBlock(mineTestBlock(t, state, babaYaga.addr, []SignedTx{tx})); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,213 @@
+package database
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Formats of a chain export.
+const (
+	// ExportFormatJSONL writes a header line followed by one stored block per line, it can be imported back.
+	ExportFormatJSONL = "jsonl"
+
+	// ExportFormatCSV writes one row per transaction for spreadsheets, it can't be imported.
+	ExportFormatCSV = "csv"
+)
+
+// ExportHeader is the first line of a JSONL export, so blocks are only imported into a chain of the same genesis.
+type ExportHeader struct {
+	ChainID     string `json:"chain_id"`
+	GenesisHash Hash   `json:"genesis_hash"`
+	FromHeight  uint64 `json:"from_height"`
+	ToHeight    uint64 `json:"to_height"`
+}
+
+var exportCSVColumns = []string{
+	"block_height", "block_hash", "block_time", "miner", "tx_index", "tx_hash", "type",
+	"from", "to", "value", "gas", "gas_price", "fee", "nonce", "data", "time", "chain_id",
+}
+
+// ExportBlocks writes the main chain blocks from the given height to the given one, both included, in the format.
+// It returns the number of exported blocks.
+func (s *State) ExportBlocks(w io.Writer, format string, from uint64, to uint64) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if !s.hasGenesisBlock {
+		return 0, fmt.Errorf("there are no blocks to export yet")
+	}
+
+	if to > s.latestBlock.Header.Number {
+		to = s.latestBlock.Header.Number
+	}
+
+	if from > to {
+		return 0, fmt.Errorf("export range %d-%d is empty, the chain ends at block %d", from, to, s.latestBlock.Header.Number)
+	}
+
+	var write func(blockFs BlockFS) error
+	var flush func() error
+
+	switch format {
+	case ExportFormatJSONL:
+		buffered := bufio.NewWriter(w)
+		encoder := json.NewEncoder(buffered)
+
+		if err := encoder.Encode(ExportHeader{s.chainID, s.genesisHash, from, to}); err != nil {
+			return 0, err
+		}
+
+		write = func(blockFs BlockFS) error {
+			return encoder.Encode(blockFs)
+		}
+		flush = buffered.Flush
+	case ExportFormatCSV:
+		csvWriter := csv.NewWriter(w)
+
+		if err := csvWriter.Write(exportCSVColumns); err != nil {
+			return 0, err
+		}
+
+		write = func(blockFs BlockFS) error {
+			return s.writeCSVBlock(csvWriter, blockFs)
+		}
+		flush = func() error {
+			csvWriter.Flush()
+			return csvWriter.Error()
+		}
+	default:
+		return 0, fmt.Errorf("unknown export format '%s', expected '%s' or '%s'", format, ExportFormatJSONL, ExportFormatCSV)
+	}
+
+	exported := 0
+	err := s.store.ForEach(from, func(blockFs BlockFS) error {
+		if blockFs.Value.Header.Number > to {
+			return errHeightReached
+		}
+
+		exported++
+
+		return write(blockFs)
+	})
+	if err != nil && err != errHeightReached {
+		return exported, err
+	}
+
+	return exported, flush()
+}
+
+// writeCSVBlock writes a row per transaction of the block.
+func (s *State) writeCSVBlock(w *csv.Writer, blockFs BlockFS) error {
+	header := blockFs.Value.Header
+	rules := s.RulesAt(header.Number)
+
+	// the transfers of the legacy block pay no fee
+	isLegacy := blockFs.Key == s.legacyBlock
+
+	for i, tx := range blockFs.Value.TXs {
+		hash, err := tx.Hash()
+		if err != nil {
+			return err
+		}
+
+		fee := NewAmount(0)
+		if !isLegacy {
+			if fee, err = tx.Fee(rules); err != nil {
+				return err
+			}
+		}
+
+		txType := "transfer"
+		if s.isMintTx(tx.Tx) || (isLegacy && tx.IsMint()) {
+			txType = "mint"
+		}
+
+		err = w.Write([]string{
+			strconv.FormatUint(header.Number, 10),
+			blockFs.Key.Hex(),
+			strconv.FormatUint(header.Time, 10),
+			header.Miner.String(),
+			strconv.Itoa(i),
+			hash.Hex(),
+			txType,
+			tx.From.String(),
+			tx.To.String(),
+			tx.Value.String(),
+			strconv.FormatUint(uint64(tx.Gas), 10),
+			tx.GasPrice.String(),
+			fee.String(),
+			strconv.FormatUint(uint64(tx.Nonce), 10),
+			tx.Data,
+			strconv.FormatUint(tx.Time, 10),
+			tx.ChainID,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ImportBlocks appends the blocks of a JSONL export to the chain, applying them with the rules of AddBlock.
+//
+// Blocks the chain already has are skipped, so overlapping exports can be imported one after the other.
+// It returns the number of imported and skipped blocks.
+func (s *State) ImportBlocks(r io.Reader) (int, int, error) {
+	decoder := json.NewDecoder(bufio.NewReader(r))
+
+	var header ExportHeader
+	if err := decoder.Decode(&header); err != nil {
+		return 0, 0, fmt.Errorf("invalid export header: %s", err.Error())
+	}
+
+	if header.GenesisHash != s.genesisHash {
+		return 0, 0, fmt.Errorf("export of chain '%s' with genesis '%s' can't be imported into chain '%s' with genesis '%s'", header.ChainID, header.GenesisHash.Hex(), s.chainID, s.genesisHash.Hex())
+	}
+
+	imported, skipped := 0, 0
+
+	for {
+		var blockFs BlockFS
+		err := decoder.Decode(&blockFs)
+		if err == io.EOF {
+			return imported, skipped, nil
+		}
+		if err != nil {
+			return imported, skipped, fmt.Errorf("invalid exported block after %d blocks: %s", imported+skipped, err.Error())
+		}
+
+		hash, err := blockFs.Value.Hash()
+		if err != nil {
+			return imported, skipped, err
+		}
+
+		if hash != blockFs.Key {
+			return imported, skipped, fmt.Errorf("exported block %d hash '%s' doesn't match its content hash '%s'", blockFs.Value.Header.Number, blockFs.Key.Hex(), hash.Hex())
+		}
+
+		if blockFs.Value.Header.Number < s.NextBlockNumber() {
+			stored, err := s.store.BlockByHeight(blockFs.Value.Header.Number)
+			if err != nil {
+				return imported, skipped, err
+			}
+
+			if stored.Key != hash {
+				return imported, skipped, fmt.Errorf("exported block %d '%s' conflicts with the stored block '%s'", blockFs.Value.Header.Number, hash.Hex(), stored.Key.Hex())
+			}
+
+			skipped++
+			continue
+		}
+
+		if _, err := s.AddBlock(blockFs.Value); err != nil {
+			return imported, skipped, fmt.Errorf("unable to import block %d '%s': %s", blockFs.Value.Header.Number, hash.Hex(), err.Error())
+		}
+
+		imported++
+	}
+}
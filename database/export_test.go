@@ -0,0 +1,67 @@
+package database
+
+import (
+	"bytes"
+	"encoding/csv"
+	"testing"
+)
+
+func TestExportImportBlocks(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+
+	genesisBalances := map[Address]Amount{andrej.addr: NewAmount(1000)}
+	state, _ := newTestState(t, genesisBalances)
+
+	for i := uint(1); i <= 3; i++ {
+		tx := andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(10), i, "a, \"quoted\" note"))
+
+		if _, err := state.AddBlock(mineTestBlock(t, state, babaYaga.addr, []SignedTx{tx})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var partial bytes.Buffer
+	if exported, err := state.ExportBlocks(&partial, ExportFormatJSONL, 0, 1); err != nil || exported != 2 {
+		t.Fatalf("blocks 0 to 1 should be exported, got %d blocks and %v", exported, err)
+	}
+
+	var full bytes.Buffer
+	if exported, err := state.ExportBlocks(&full, ExportFormatJSONL, 0, 100); err != nil || exported != 3 {
+		t.Fatalf("the whole chain should be exported, got %d blocks and %v", exported, err)
+	}
+
+	imported, _ := newTestState(t, genesisBalances)
+
+	if n, skipped, err := imported.ImportBlocks(&partial); err != nil || n != 2 || skipped != 0 {
+		t.Fatalf("2 blocks should be imported, got %d imported, %d skipped and %v", n, skipped, err)
+	}
+
+	// the overlapping export only appends the block the chain doesn't have
+	if n, skipped, err := imported.ImportBlocks(bytes.NewReader(full.Bytes())); err != nil || n != 1 || skipped != 2 {
+		t.Fatalf("1 block should be imported and 2 skipped, got %d imported, %d skipped and %v", n, skipped, err)
+	}
+
+	if imported.LatestBlockHash() != state.LatestBlockHash() || imported.StateRoot() != state.StateRoot() {
+		t.Fatal("imported chain should match the exported one")
+	}
+
+	other, _ := newTestState(t, map[Address]Amount{babaYaga.addr: NewAmount(1000)})
+	if _, _, err := other.ImportBlocks(bytes.NewReader(full.Bytes())); err == nil {
+		t.Fatal("blocks of another genesis should not be imported")
+	}
+
+	var table bytes.Buffer
+	if _, err := state.ExportBlocks(&table, ExportFormatCSV, 0, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := csv.NewReader(&table).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rows) != 4 || rows[0][0] != "block_height" || rows[3][0] != "2" || rows[3][14] != "a, \"quoted\" note" {
+		t.Fatalf("CSV should have a header and a row per tx, got %v", rows)
+	}
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"os"
+)
+
+var fileIndexMagic = []byte("GAMMAIDX")
+
+const fileIndexVersion = uint32(1)
+
+// fileIndexEntry is one record of the block.idx file: where the block with the hash and height starts in block.db.
+type fileIndexEntry struct {
+	Hash   Hash
+	Height uint64
+	Pos    int64
+}
+
+const fileIndexEntrySize = HashLength + 8 + 8
+
+// writeFileIndex persists the positions of the records covering the first dbSize bytes of block.db.
+//
+// Layout: magic, version, dbSize, count, entries, sha256 of everything before it.
+// The file is written next to the final path and renamed over it, so a crash never leaves a half written index.
+func writeFileIndex(path string, dbSize int64, entries []fileIndexEntry) error {
+	buf := bytes.NewBuffer(make([]byte, 0, len(fileIndexMagic)+20+len(entries)*fileIndexEntrySize+sha256.Size))
+
+	buf.Write(fileIndexMagic)
+	binary.Write(buf, binary.BigEndian, fileIndexVersion)
+	binary.Write(buf, binary.BigEndian, dbSize)
+	binary.Write(buf, binary.BigEndian, uint64(len(entries)))
+
+	for _, e := range entries {
+		buf.Write(e.Hash[:])
+		binary.Write(buf, binary.BigEndian, e.Height)
+		binary.Write(buf, binary.BigEndian, e.Pos)
+	}
+
+	checksum := sha256.Sum256(buf.Bytes())
+	buf.Write(checksum[:])
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// readFileIndex loads an index written by writeFileIndex and returns the block.db size it covers.
+func readFileIndex(path string) (int64, []fileIndexEntry, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	headerSize := len(fileIndexMagic) + 20
+	if len(content) < headerSize+sha256.Size {
+		return 0, nil, fmt.Errorf("index is truncated")
+	}
+
+	body, checksum := content[:len(content)-sha256.Size], content[len(content)-sha256.Size:]
+	if sum := sha256.Sum256(body); !bytes.Equal(sum[:], checksum) {
+		return 0, nil, fmt.Errorf("index checksum mismatch")
+	}
+
+	if !bytes.Equal(body[:len(fileIndexMagic)], fileIndexMagic) {
+		return 0, nil, fmt.Errorf("not a block index")
+	}
+	body = body[len(fileIndexMagic):]
+
+	if version := binary.BigEndian.Uint32(body[0:4]); version != fileIndexVersion {
+		return 0, nil, fmt.Errorf("unsupported index version %d", version)
+	}
+
+	dbSize := int64(binary.BigEndian.Uint64(body[4:12]))
+	count := binary.BigEndian.Uint64(body[12:20])
+	body = body[20:]
+
+	if uint64(len(body)) != count*fileIndexEntrySize {
+		return 0, nil, fmt.Errorf("index holds %d bytes of entries, expected %d", len(body), count*fileIndexEntrySize)
+	}
+
+	entries := make([]fileIndexEntry, count)
+	for i := range entries {
+		e := body[i*fileIndexEntrySize : (i+1)*fileIndexEntrySize]
+
+		copy(entries[i].Hash[:], e[:HashLength])
+		entries[i].Height = binary.BigEndian.Uint64(e[HashLength : HashLength+8])
+		entries[i].Pos = int64(binary.BigEndian.Uint64(e[HashLength+8:]))
+	}
+
+	return dbSize, entries, nil
+}
@@ -0,0 +1,573 @@
+package database
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+)
+
+var recordChecksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// recordChecksumLen is the length of the tab separated CRC-32C hex suffix of a JSON record.
+const recordChecksumLen = 1 + 8
+
+// binaryRecordMarker starts a binary record, telling it apart from a JSON record starting with '{'.
+const binaryRecordMarker = byte(0xb1)
+
+// binaryRecordHeaderLen is the length of the marker and big endian payload length preceding a binary record payload.
+const binaryRecordHeaderLen = 1 + 4
+
+// fileIndexInterval is the number of records after which Append persists the index.
+var fileIndexInterval = 1000
+
+// fileBlockStore keeps blocks as records appended to block.db.
+//
+// A record is the marker byte, the big endian length of the encoded BlockFS, the encoded BlockFS and its CRC-32C.
+// Records written before the binary encoding are newline-delimited BlockFS JSON, ending with a tab and the CRC-32C
+// of the JSON if they were written after checksums were introduced, and are still read.
+// Every record is fsynced before Append returns. A final record torn by a crash mid-write is truncated
+// when the store is opened.
+//
+// Positions of every record are cached in file order, with the record index looked up by hash or height,
+// so single blocks can be read without a scan. The positions are persisted to block.idx every fileIndexInterval
+// records and on close, so opening the store only has to read the records appended since, even after a crash.
+//
+// The cached positions are guarded by a lock, records are read with ReadAt, so blocks can be read while others
+// are appended.
+type fileBlockStore struct {
+	mu sync.RWMutex
+
+	f    *os.File
+	size int64
+
+	indexPath   string
+	indexedSize int64
+
+	entries     []fileIndexEntry
+	hashCache   map[Hash]int
+	heightCache map[uint64]int
+}
+
+func openFileBlockStore(path, indexPath string) (*fileBlockStore, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &fileBlockStore{f: f, indexPath: indexPath}
+
+	err = s.loadIndex()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Printf("Rebuilding block index '%s': %s\n", indexPath, err.Error())
+		}
+
+		s.resetCache()
+		s.size = 0
+		s.indexedSize = -1
+	}
+
+	// pick up every record appended since the index was written
+	s.size, err = s.scan(s.size, 1<<62, func(blockFs BlockFS, pos int64) error {
+		s.cache(blockFs, pos)
+		return nil
+	})
+	if torn, ok := err.(*tornRecordError); ok {
+		err = s.truncateTornRecord(torn)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// tornRecordError reports the last record of block.db being incomplete or corrupted,
+// which is what a crash in the middle of Append leaves behind.
+type tornRecordError struct {
+	pos    int64
+	record []byte
+	reason string
+}
+
+func (e *tornRecordError) Error() string {
+	return fmt.Sprintf("torn block record at position %d (%d bytes): %s", e.pos, len(e.record), e.reason)
+}
+
+func (s *fileBlockStore) truncateTornRecord(torn *tornRecordError) error {
+	preview := torn.record
+	if len(preview) > 64 {
+		preview = preview[:64]
+	}
+
+	fmt.Printf("WARNING: dropping %s\n", torn.Error())
+	fmt.Printf("\tdropped bytes start with: %q\n", preview)
+
+	if err := s.f.Truncate(torn.pos); err != nil {
+		return err
+	}
+
+	s.size = torn.pos
+
+	return s.f.Sync()
+}
+
+func encodeRecord(blockFs BlockFS) ([]byte, error) {
+	encoded, err := encodeBlockFS(blockFs)
+	if err != nil {
+		return nil, err
+	}
+
+	checksum := crc32.Checksum(encoded, recordChecksumTable)
+
+	if isJSONRecord(encoded) {
+		return append(encoded, fmt.Sprintf("\t%08x\n", checksum)...), nil
+	}
+
+	record := append([]byte{binaryRecordMarker}, binary.BigEndian.AppendUint32(nil, uint32(len(encoded)))...)
+	record = append(record, encoded...)
+
+	return binary.BigEndian.AppendUint32(record, checksum), nil
+}
+
+// readRecord reads the next record, binary or newline terminated JSON, without decoding it.
+//
+// It returns io.EOF when there are no more records and io.ErrUnexpectedEOF along with the bytes read
+// when the last record is incomplete.
+func readRecord(reader *bufio.Reader) ([]byte, error) {
+	first, err := reader.Peek(1)
+	if err == io.EOF || (err == nil && first[0] == '\n') {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if first[0] != binaryRecordMarker {
+		record, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			return record, io.ErrUnexpectedEOF
+		}
+
+		return record, err
+	}
+
+	var record bytes.Buffer
+	if _, err := io.CopyN(&record, reader, binaryRecordHeaderLen); err != nil {
+		return record.Bytes(), unexpectedEOF(err)
+	}
+
+	payloadLen := binary.BigEndian.Uint32(record.Bytes()[1:])
+	if _, err := io.CopyN(&record, reader, int64(payloadLen)+4); err != nil {
+		return record.Bytes(), unexpectedEOF(err)
+	}
+
+	return record.Bytes(), nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// decodeRecord parses a record read by readRecord, verifying its checksum if it has one.
+func decodeRecord(record []byte) (BlockFS, error) {
+	if len(record) > 0 && record[0] == binaryRecordMarker {
+		if len(record) < binaryRecordHeaderLen+4 {
+			return BlockFS{}, fmt.Errorf("record is too short")
+		}
+
+		encoded := record[binaryRecordHeaderLen : len(record)-4]
+		checksum := binary.BigEndian.Uint32(record[len(record)-4:])
+
+		if crc32.Checksum(encoded, recordChecksumTable) != checksum {
+			return BlockFS{}, fmt.Errorf("record checksum mismatch")
+		}
+
+		return decodeBlockFS(encoded)
+	}
+
+	var blockFs BlockFS
+
+	blockFsJson := bytes.TrimSuffix(record, []byte{'\n'})
+
+	if i := len(blockFsJson) - recordChecksumLen; i >= 0 && blockFsJson[i] == '\t' {
+		checksum, err := strconv.ParseUint(string(blockFsJson[i+1:]), 16, 32)
+		if err != nil {
+			return blockFs, fmt.Errorf("invalid record checksum")
+		}
+
+		blockFsJson = blockFsJson[:i]
+
+		if crc32.Checksum(blockFsJson, recordChecksumTable) != uint32(checksum) {
+			return blockFs, fmt.Errorf("record checksum mismatch")
+		}
+	}
+
+	err := json.Unmarshal(blockFsJson, &blockFs)
+	if err != nil {
+		return blockFs, err
+	}
+
+	return blockFs, nil
+}
+
+// loadIndex restores the cached positions from block.idx after checking it still matches block.db.
+func (s *fileBlockStore) loadIndex() error {
+	dbSize, entries, err := readFileIndex(s.indexPath)
+	if err != nil {
+		return err
+	}
+
+	info, err := s.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if dbSize > info.Size() {
+		return fmt.Errorf("index covers %d bytes but block.db has only %d", dbSize, info.Size())
+	}
+
+	if err := s.checkIndexEntries(entries, dbSize); err != nil {
+		return err
+	}
+
+	s.resetCache()
+	s.size = dbSize
+
+	if len(entries) == 0 {
+		if dbSize != 0 {
+			return fmt.Errorf("index has no blocks but covers %d bytes", dbSize)
+		}
+	} else {
+		for _, e := range []fileIndexEntry{entries[0], entries[len(entries)-1]} {
+			blockFs, end, err := s.readRecordAt(e.Pos)
+			if err != nil {
+				return fmt.Errorf("indexed block %d is unreadable. %s", e.Height, err.Error())
+			}
+
+			if blockFs.Key != e.Hash || blockFs.Value.Header.Number != e.Height {
+				return fmt.Errorf("indexed block %d doesn't match block.db", e.Height)
+			}
+
+			if e == entries[len(entries)-1] && end != dbSize {
+				return fmt.Errorf("last indexed block ends at %d, not at %d", end, dbSize)
+			}
+		}
+	}
+
+	for _, e := range entries {
+		s.hashCache[e.Hash] = len(s.entries)
+		s.heightCache[e.Height] = len(s.entries)
+		s.entries = append(s.entries, e)
+	}
+	s.indexedSize = dbSize
+
+	return nil
+}
+
+// checkIndexEntries checks the entries describe consecutive blocks whose records follow each other from the start
+// of block.db up to dbSize, reading only where every record starts and ends.
+//
+// The checksum of block.idx only tells the index wasn't corrupted, not that it still describes block.db.
+func (s *fileBlockStore) checkIndexEntries(entries []fileIndexEntry, dbSize int64) error {
+	seen := make(map[Hash]bool, len(entries))
+	header := make([]byte, binaryRecordHeaderLen)
+	last := make([]byte, 1)
+
+	for i, e := range entries {
+		if seen[e.Hash] {
+			return fmt.Errorf("block %s is indexed twice", e.Hash.Hex())
+		}
+		seen[e.Hash] = true
+
+		if i == 0 && e.Pos != 0 {
+			return fmt.Errorf("first indexed block starts at %d, not at 0", e.Pos)
+		}
+
+		if i > 0 && e.Height != entries[i-1].Height+1 {
+			return fmt.Errorf("indexed block %d follows block %d", e.Height, entries[i-1].Height)
+		}
+
+		end := dbSize
+		if i+1 < len(entries) {
+			end = entries[i+1].Pos
+		}
+
+		if end-e.Pos < int64(len(header)) {
+			return fmt.Errorf("indexed block %d spans %d bytes", e.Height, end-e.Pos)
+		}
+
+		if _, err := s.f.ReadAt(header, e.Pos); err != nil {
+			return err
+		}
+
+		switch header[0] {
+		case binaryRecordMarker:
+			if recordEnd := e.Pos + binaryRecordHeaderLen + int64(binary.BigEndian.Uint32(header[1:])) + 4; recordEnd != end {
+				return fmt.Errorf("indexed block %d ends at %d, not at %d", e.Height, recordEnd, end)
+			}
+		case '{':
+			if _, err := s.f.ReadAt(last, end-1); err != nil {
+				return err
+			}
+
+			if last[0] != '\n' {
+				return fmt.Errorf("indexed block %d doesn't end at %d", e.Height, end)
+			}
+		default:
+			return fmt.Errorf("no record starts where block %d is indexed", e.Height)
+		}
+	}
+
+	return nil
+}
+
+func (s *fileBlockStore) resetCache() {
+	s.entries = nil
+	s.hashCache = map[Hash]int{}
+	s.heightCache = map[uint64]int{}
+}
+
+// scan reads every record between the given positions and returns the end of the last one.
+//
+// An unreadable last record is reported as a *tornRecordError, an unreadable record followed by others fails the scan.
+func (s *fileBlockStore) scan(from int64, to int64, fn func(blockFs BlockFS, pos int64) error) (int64, error) {
+	reader := bufio.NewReader(io.NewSectionReader(s.f, from, to-from))
+	pos := from
+
+	for {
+		record, err := readRecord(reader)
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			return pos, &tornRecordError{pos, record, "record is not complete"}
+		}
+		if err != nil {
+			return pos, err
+		}
+
+		blockFs, err := decodeRecord(record)
+		if err != nil {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				return pos, &tornRecordError{pos, record, err.Error()}
+			}
+
+			return pos, fmt.Errorf("unable to read block at position %d. %s", pos, err.Error())
+		}
+
+		if err := fn(blockFs, pos); err != nil {
+			return pos, err
+		}
+
+		pos += int64(len(record))
+	}
+
+	return pos, nil
+}
+
+func (s *fileBlockStore) cache(blockFs BlockFS, pos int64) {
+	s.hashCache[blockFs.Key] = len(s.entries)
+	s.heightCache[blockFs.Value.Header.Number] = len(s.entries)
+	s.entries = append(s.entries, fileIndexEntry{blockFs.Key, blockFs.Value.Header.Number, pos})
+}
+
+func (s *fileBlockStore) Append(blockFs BlockFS) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, err := encodeRecord(blockFs)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.f.Write(record)
+	if err == nil {
+		err = s.f.Sync()
+	}
+	if err != nil {
+		// don't leave a partial record behind for the next Append to write after
+		s.f.Truncate(s.size)
+		return err
+	}
+
+	s.cache(blockFs, s.size)
+	s.size += int64(len(record))
+
+	// the block is stored either way, an index which couldn't be written is only a longer scan on the next open
+	if len(s.entries)%fileIndexInterval == 0 {
+		if err := s.writeIndex(); err != nil {
+			fmt.Printf("WARNING: unable to write block index '%s': %s\n", s.indexPath, err.Error())
+		}
+	}
+
+	return nil
+}
+
+func (s *fileBlockStore) BlockByHash(hash Hash) (BlockFS, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i, ok := s.hashCache[hash]
+	if !ok {
+		return BlockFS{}, fmt.Errorf("invalid hash: '%v', %w", hash.Hex(), errBlockNotFound)
+	}
+
+	return s.readAt(s.entries[i].Pos)
+}
+
+func (s *fileBlockStore) BlockByHeight(height uint64) (BlockFS, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i, ok := s.heightCache[height]
+	if !ok {
+		return BlockFS{}, fmt.Errorf("invalid height: '%v', %w", height, errBlockNotFound)
+	}
+
+	return s.readAt(s.entries[i].Pos)
+}
+
+func (s *fileBlockStore) BlocksAfter(hash Hash) ([]Block, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	blocks := make([]Block, 0)
+
+	next := 0
+	if !hash.IsEmpty() {
+		i, ok := s.hashCache[hash]
+		if !ok {
+			return blocks, nil
+		}
+
+		next = i + 1
+	}
+
+	for _, e := range s.entries[next:] {
+		blockFs, err := s.readAt(e.Pos)
+		if err != nil {
+			return nil, err
+		}
+
+		blocks = append(blocks, blockFs.Value)
+	}
+
+	return blocks, nil
+}
+
+// ForEach reads the records stored when it's called, without holding the lock while fn runs.
+func (s *fileBlockStore) ForEach(from uint64, fn func(BlockFS) error) error {
+	s.mu.RLock()
+	pos := int64(0)
+	if from > 0 {
+		i, ok := s.heightCache[from]
+		if !ok {
+			s.mu.RUnlock()
+			return nil
+		}
+
+		pos = s.entries[i].Pos
+	}
+	size := s.size
+	s.mu.RUnlock()
+
+	_, err := s.scan(pos, size, func(blockFs BlockFS, _ int64) error {
+		return fn(blockFs)
+	})
+
+	return err
+}
+
+func (s *fileBlockStore) TruncateFrom(height uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.heightCache[height]
+	if !ok {
+		return nil
+	}
+
+	// the index written later must not describe records which are gone
+	if err := os.Remove(s.indexPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.indexedSize = -1
+
+	pos := s.entries[i].Pos
+	if err := s.f.Truncate(pos); err != nil {
+		return err
+	}
+
+	for _, e := range s.entries[i:] {
+		delete(s.hashCache, e.Hash)
+		delete(s.heightCache, e.Height)
+	}
+	s.entries = s.entries[:i]
+	s.size = pos
+
+	return s.f.Sync()
+}
+
+func (s *fileBlockStore) readAt(pos int64) (BlockFS, error) {
+	blockFs, _, err := s.readRecordAt(pos)
+	return blockFs, err
+}
+
+// readRecordAt reads the record starting at pos and returns it with the position where it ends.
+func (s *fileBlockStore) readRecordAt(pos int64) (BlockFS, int64, error) {
+	reader := bufio.NewReader(io.NewSectionReader(s.f, pos, s.size-pos))
+	record, err := readRecord(reader)
+	if err == io.EOF {
+		return BlockFS{}, 0, fmt.Errorf("no record at position %d", pos)
+	}
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return BlockFS{}, 0, err
+	}
+
+	blockFs, err := decodeRecord(record)
+	if err != nil {
+		return blockFs, 0, err
+	}
+
+	return blockFs, pos + int64(len(record)), nil
+}
+
+// writeIndex persists the cached positions unless block.idx already covers every record.
+func (s *fileBlockStore) writeIndex() error {
+	if s.size == s.indexedSize {
+		return nil
+	}
+
+	if err := writeFileIndex(s.indexPath, s.size, s.entries); err != nil {
+		return err
+	}
+	s.indexedSize = s.size
+
+	return nil
+}
+
+func (s *fileBlockStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.writeIndex()
+
+	if closeErr := s.f.Close(); err == nil {
+		err = closeErr
+	}
+
+	return err
+}
@@ -0,0 +1,250 @@
+package database
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func testBlockFS(number uint64) BlockFS {
+	b := NewBlock(Hash{}, number, uint32(number), number, Address{}, nil)
+
+	hash, _ := b.Hash()
+
+	return BlockFS{hash, b}
+}
+
+func openTestFileBlockStore(t *testing.T, dir string) *fileBlockStore {
+	dbPath := filepath.Join(dir, "block.db")
+	if !fileExist(dbPath) {
+		if err := writeEmptyBlocksDbToDisk(dbPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := openFileBlockStore(dbPath, filepath.Join(dir, "block.idx"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return s
+}
+
+func requireIndexedBlocks(t *testing.T, s *fileBlockStore, blocks []BlockFS) {
+	if len(s.entries) != len(blocks) {
+		t.Fatalf("store should index %d blocks, not %d", len(blocks), len(s.entries))
+	}
+
+	for _, b := range blocks {
+		byHash, err := s.BlockByHash(b.Key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		byHeight, err := s.BlockByHeight(b.Value.Header.Number)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if byHash.Key != b.Key || byHeight.Key != b.Key {
+			t.Fatalf("block %d lookups returned %s and %s, expected %s", b.Value.Header.Number, byHash.Key.Hex(), byHeight.Key.Hex(), b.Key.Hex())
+		}
+	}
+}
+
+func TestFileBlockStoreIndex(t *testing.T) {
+	dir := t.TempDir()
+	indexPath := filepath.Join(dir, "block.idx")
+
+	var blocks []BlockFS
+	s := openTestFileBlockStore(t, dir)
+	for i := uint64(0); i < 3; i++ {
+		blocks = append(blocks, testBlockFS(i))
+		if err := s.Append(blocks[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dbSize, entries, err := readFileIndex(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || dbSize != s.size {
+		t.Fatalf("index should cover 3 blocks and %d bytes, got %d blocks and %d bytes", s.size, len(entries), dbSize)
+	}
+
+	// a block appended by a node that crashed before closing the store is picked up from block.db
+	s = openTestFileBlockStore(t, dir)
+	if s.indexedSize != dbSize {
+		t.Fatalf("store should have been opened from the index covering %d bytes", dbSize)
+	}
+	blocks = append(blocks, testBlockFS(3))
+	if err := s.Append(blocks[3]); err != nil {
+		t.Fatal(err)
+	}
+	s.f.Close()
+
+	s = openTestFileBlockStore(t, dir)
+	requireIndexedBlocks(t, s, blocks)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a corrupted index is rebuilt from block.db
+	content, err := os.ReadFile(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[len(content)/2] ^= 0xff
+	if err := os.WriteFile(indexPath, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestFileBlockStore(t, dir)
+	if s.indexedSize != -1 {
+		t.Fatal("corrupted index should have been rebuilt")
+	}
+	requireIndexedBlocks(t, s, blocks)
+	s.Close()
+}
+
+func TestFileBlockStoreIndexWrittenOnAppend(t *testing.T) {
+	defer func(interval int) { fileIndexInterval = interval }(fileIndexInterval)
+	fileIndexInterval = 2
+
+	dir := t.TempDir()
+	indexPath := filepath.Join(dir, "block.idx")
+
+	var blocks []BlockFS
+	s := openTestFileBlockStore(t, dir)
+	for i := uint64(0); i < 3; i++ {
+		blocks = append(blocks, testBlockFS(i))
+		if err := s.Append(blocks[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a node killed before closing the store
+	s.f.Close()
+
+	_, entries, err := readFileIndex(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("index should have been written after 2 blocks, it covers %d", len(entries))
+	}
+
+	s = openTestFileBlockStore(t, dir)
+	if s.indexedSize == -1 {
+		t.Fatal("store should have been opened from the index written on append")
+	}
+	requireIndexedBlocks(t, s, blocks)
+	s.Close()
+}
+
+func TestFileBlockStoreIndexEntriesChecked(t *testing.T) {
+	dir := t.TempDir()
+	indexPath := filepath.Join(dir, "block.idx")
+
+	var blocks []BlockFS
+	s := openTestFileBlockStore(t, dir)
+	for i := uint64(0); i < 3; i++ {
+		blocks = append(blocks, testBlockFS(i))
+		if err := s.Append(blocks[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dbSize, entries, err := readFileIndex(indexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a well formed index whose middle entry doesn't point at a record
+	entries[1].Pos++
+	if err := writeFileIndex(indexPath, dbSize, entries); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestFileBlockStore(t, dir)
+	if s.indexedSize != -1 {
+		t.Fatal("index describing another block.db should have been rebuilt")
+	}
+	requireIndexedBlocks(t, s, blocks)
+	s.Close()
+}
+
+func TestFileBlockStoreTornTailRecovery(t *testing.T) {
+	dir := t.TempDir()
+	dbPath := filepath.Join(dir, "block.db")
+
+	// a block written before records were checksummed must still be readable
+	legacy := testBlockFS(0)
+	legacyJson, err := json.Marshal(legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dbPath, append(legacyJson, '\n'), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	blocks := []BlockFS{legacy, testBlockFS(1)}
+	s := openTestFileBlockStore(t, dir)
+	if err := s.Append(blocks[1]); err != nil {
+		t.Fatal(err)
+	}
+	size := s.size
+	s.f.Close()
+
+	torn, err := encodeRecord(testBlockFS(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	garbled := append([]byte{}, torn...)
+	garbled[len(garbled)/2] ^= 0xff
+
+	for _, tail := range [][]byte{torn[:len(torn)/2], garbled} {
+		f, err := os.OpenFile(dbPath, os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write(tail)
+		f.Close()
+
+		s = openTestFileBlockStore(t, dir)
+		requireIndexedBlocks(t, s, blocks)
+		s.Close()
+
+		info, err := os.Stat(dbPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != size {
+			t.Fatalf("torn record should have been truncated, block.db has %d bytes instead of %d", info.Size(), size)
+		}
+	}
+
+	// a corrupted record followed by valid ones is not a torn write and must not be silently dropped
+	content, err := os.ReadFile(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupted := append([]byte{}, content...)
+	corrupted[len(legacyJson)+1+binaryRecordHeaderLen+2] ^= 0xff
+	if err := os.WriteFile(dbPath, append(corrupted, torn...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(dir, "block.idx"))
+
+	if _, err := openFileBlockStore(dbPath, filepath.Join(dir, "block.idx")); err == nil {
+		t.Fatal("opening a block.db with a corrupted record in the middle should fail")
+	}
+}
@@ -0,0 +1,83 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Names of the protocol upgrades the node implements.
+const (
+	// ForkTIP1 replaces the flat TxFee by gas paid to the miner.
+	ForkTIP1 = "tip1"
+
+	// ForkTIP2 requires transactions to be signed for the chain ID of the genesis.
+	ForkTIP2 = "tip2"
+
+	// ForkTIP3 requires block times not to precede the median time of the latest blocks nor to be far in the future.
+	ForkTIP3 = "tip3"
+
+	// ForkTIP4 requires blocks and transactions to use the binary encoding, the legacy JSON one stays valid before it,
+	// and blocks to commit to their txs with a tx root and to the resulting state with a state root.
+	ForkTIP4 = "tip4"
+)
+
+// knownForks lists every fork a genesis can schedule. A new fork is added here, as a field of Rules
+// and to NewRules, and the code it changes consults that field.
+var knownForks = []string{ForkTIP1, ForkTIP2, ForkTIP3, ForkTIP4}
+
+// Forks maps the name of every scheduled fork to the height of the first block it applies to.
+type Forks map[string]uint64
+
+func (f Forks) validate() error {
+	for name := range f {
+		known := false
+		for _, knownFork := range knownForks {
+			known = known || name == knownFork
+		}
+
+		if !known {
+			return fmt.Errorf("unknown fork '%s', this node implements %v", name, knownForks)
+		}
+	}
+
+	return nil
+}
+
+// IsActive tells if the fork applies to the block at the given height. An unscheduled fork never does.
+func (f Forks) IsActive(name string, height uint64) bool {
+	activation, ok := f[name]
+	return ok && height >= activation
+}
+
+// names returns the scheduled forks sorted by name.
+func (f Forks) names() []string {
+	names := make([]string, 0, len(f))
+	for name := range f {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Rules are the consensus rules of the block at a height, depending on the forks active at it.
+type Rules struct {
+	Height      uint64
+	BlockReward Amount
+
+	IsTIP1 bool
+	IsTIP2 bool
+	IsTIP3 bool
+	IsTIP4 bool
+}
+
+func NewRules(forks Forks, blockReward Amount, height uint64) Rules {
+	return Rules{
+		Height:      height,
+		BlockReward: blockReward,
+		IsTIP1:      forks.IsActive(ForkTIP1, height),
+		IsTIP2:      forks.IsActive(ForkTIP2, height),
+		IsTIP3:      forks.IsActive(ForkTIP3, height),
+		IsTIP4:      forks.IsActive(ForkTIP4, height),
+	}
+}
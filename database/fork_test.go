@@ -0,0 +1,121 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestForks(t *testing.T) {
+	// genesis files written before the forks registry schedule TIP1 on their own
+	legacy := writeTestGenesis(t, `{"symbol":"TGL","balances":{},"fork_tip_1":35}`)
+	if legacy.Forks[ForkTIP1] != 35 {
+		t.Fatalf("TIP1 should activate at 35, not %d", legacy.Forks[ForkTIP1])
+	}
+
+	gen := writeTestGenesis(t, `{"symbol":"TGL","balances":{},"block_reward":7,"forks":{"tip1":10}}`)
+
+	legacyHash, err := legacy.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, err := gen.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if legacyHash == hash {
+		t.Fatal("genesis scheduling forks at other heights should have another hash")
+	}
+
+	before := NewRules(gen.Forks, gen.BlockReward, 9)
+	after := NewRules(gen.Forks, gen.BlockReward, 10)
+
+	if before.IsTIP1 || !after.IsTIP1 {
+		t.Fatalf("TIP1 should apply from block 10 on, got %t at 9 and %t at 10", before.IsTIP1, after.IsTIP1)
+	}
+
+	tx := NewBaseTx(Address{}, Address{}, NewAmount(100), 1, "")
+	costBefore, _ := tx.Cost(before)
+	costAfter, _ := tx.Cost(after)
+	if costBefore != NewAmount(100+uint64(TxFee)) || costAfter != NewAmount(100+TxGas*TxGasPriceDefault) {
+		t.Fatalf("tx cost should be %d before TIP1 and %d after, not %s and %s", 100+TxFee, 100+TxGas*TxGasPriceDefault, costBefore, costAfter)
+	}
+
+	b := Block{TXs: []SignedTx{NewSignedTx(tx, nil)}}
+	rewardBefore, _ := b.MinerReward(before)
+	rewardAfter, _ := b.MinerReward(after)
+	if rewardBefore != NewAmount(7+uint64(TxFee)) || rewardAfter != NewAmount(7+TxGas*TxGasPriceDefault) {
+		t.Fatalf("miner reward should be %d before TIP1 and %d after, not %s and %s", 7+TxFee, 7+TxGas*TxGasPriceDefault, rewardBefore, rewardAfter)
+	}
+
+	// a node must not run a chain scheduling a fork it doesn't implement
+	path := filepath.Join(t.TempDir(), "genesis.json")
+	if err := os.WriteFile(path, []byte(`{"symbol":"TGL","balances":{},"forks":{"tip99":1}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadGenesis(path); err == nil {
+		t.Fatal("loading a genesis with an unknown fork should fail")
+	}
+}
+
+func TestChainIDReplayProtection(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+
+	state, _ := newTestStateFromGenesis(t, Genesis{
+		ChainID:     "gamma-test",
+		Symbol:      "TGL",
+		Balances:    map[Address]Amount{andrej.addr: NewAmount(1000)},
+		Difficulty:  testMiningDifficulty,
+		BlockReward: NewAmount(BlockReward),
+		Forks:       Forks{ForkTIP1: 0, ForkTIP2: 1},
+	})
+
+	signFor := func(chainID string, nonce uint) SignedTx {
+		tx := NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(10), nonce, "")
+		tx.ChainID = chainID
+		return andrej.sign(t, tx)
+	}
+
+	// a tx of the staging chain is never valid, a tx without chain ID only before TIP2
+	if _, err := state.AddBlock(mineTestBlock(t, state, babaYaga.addr, []SignedTx{signFor("gamma-staging", 1)})); err == nil {
+		t.Fatal("a tx signed for another chain should be rejected")
+	}
+
+	if _, err := state.AddBlock(mineTestBlock(t, state, babaYaga.addr, []SignedTx{signFor("", 1)})); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := state.AddBlock(mineTestBlock(t, state, babaYaga.addr, []SignedTx{signFor("", 2)})); err == nil {
+		t.Fatal("a tx without chain ID should be rejected once TIP2 is active")
+	}
+
+	// the chain ID is part of the signed payload, it can't be changed after signing
+	replayed := signFor("gamma-staging", 2)
+	replayed.ChainID = "gamma-test"
+	if ok, err := replayed.IsAuthentic(); err != nil || ok {
+		t.Fatal("a tx signed for another chain shouldn't be authentic with the chain ID changed")
+	}
+
+	if _, err := state.AddBlock(mineTestBlock(t, state, babaYaga.addr, []SignedTx{signFor("gamma-test", 2)})); err != nil {
+		t.Fatal(err)
+	}
+
+	// TIP2 requires a chain ID to sign for
+	gen := writeTestGenesis(t, `{"symbol":"TGL","balances":{},"chain_id":"gamma-test","forks":{"tip2":1}}`)
+	if gen.Forks[ForkTIP2] != 1 {
+		t.Fatalf("TIP2 should activate at 1, not %d", gen.Forks[ForkTIP2])
+	}
+
+	path := filepath.Join(t.TempDir(), "genesis.json")
+	if err := os.WriteFile(path, []byte(`{"symbol":"TGL","balances":{},"forks":{"tip2":1}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := loadGenesis(path); err == nil {
+		t.Fatal("loading a genesis scheduling TIP2 without a chain ID should fail")
+	}
+}
@@ -1,6 +1,7 @@
 package database
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -38,6 +39,38 @@ func getBlocksDbFilePath(dataDir string) string {
 	return filepath.Join(getDatabaseDirPath(dataDir), "block.db")
 }
 
+func getBlocksIndexFilePath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "block.idx")
+}
+
+func getBlocksKVDirPath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "block.kv")
+}
+
+func getSideChainDirPath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "sidechain.kv")
+}
+
+func getReorgJournalFilePath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "reorg.json")
+}
+
+func getUndoJournalDirPath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "undo.kv")
+}
+
+func getChainIndexDirPath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "index.kv")
+}
+
+func getSnapshotsDirPath(dataDir string) string {
+	return filepath.Join(getDatabaseDirPath(dataDir), "snapshots")
+}
+
+func getSnapshotFilePath(dataDir string, height uint64, hash Hash) string {
+	return filepath.Join(getSnapshotsDirPath(dataDir), fmt.Sprintf("%d-%s.json", height, hash.Hex()))
+}
+
 func fileExist(filePath string) bool {
 	_, err := os.Stat(filePath)
 	if err != nil && os.IsNotExist(err) {
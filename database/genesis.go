@@ -1,41 +1,176 @@
 package database
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"encoding/json"
+	"fmt"
 	"os"
+	"sort"
+	"time"
+
+	"github.com/holiman/uint256"
 )
 
+// DefaultMiningDifficulty is the mining difficulty of chains whose genesis doesn't set one.
+const DefaultMiningDifficulty = 3
+
+// Genesis specifies a chain: its identity, the initial balances and the consensus parameters every node must share.
 type Genesis struct {
-	//Time     uint64           `json:"time"`
-	Symbol   string           `json:"symbol"`
-	Balances map[Address]uint `json:"balances"`
-	ForkTIP1 uint64           `json:"fork_tip_1"`
-}
+	ChainID  string             `json:"chain_id"`
+	Time     time.Time          `json:"genesis_time"`
+	Symbol   string             `json:"symbol"`
+	Balances map[Address]Amount `json:"balances"`
+
+	// Difficulty is the number of leading zero bytes a block hash must have, DefaultMiningDifficulty if not set
+	Difficulty uint `json:"difficulty"`
+
+	// BlockReward is paid to the miner of every block on top of the fees, the BlockReward const if not set
+	BlockReward Amount `json:"block_reward"`
+
+	// Forks schedules the protocol upgrades by name and activation height
+	Forks Forks `json:"forks"`
 
-// "genesis_time": "2023-03-11T00:00:00.000000000Z",
-//	"chain_id": "the-gamma-ledger",
+	// Mint authorizes accounts to create tokens, none can if not set
+	Mint *MintPolicy `json:"mint,omitempty"`
+
+	// Legacy commits to the block 0 carrying a legacy ledger forward, set by db migrate-legacy
+	Legacy *LegacyImport `json:"legacy,omitempty"`
+
+	// ForkTIP1 is the TIP1 activation height of genesis files written before Forks, used if Forks doesn't schedule TIP1
+	ForkTIP1 uint64 `json:"fork_tip_1,omitempty"`
+}
 
 var genesisJson = `{
+	"genesis_time": "2023-03-11T00:00:00.000000000Z",
+	"chain_id": "the-gamma-ledger",
 	"symbol": "TGL",
 	"balances": {
 		"0x0000000000000000000000000000000000000001": 1000000,
 		"0x0000000000000000000000000000000000000002": 1
 	 },
-	"fork_tip_1": 35
+	"difficulty": 3,
+	"block_reward": 100,
+	"forks": {
+		"tip1": 35,
+		"tip4": 0
+	}
   }`
 
+// rlpGenesis lists the fields of a genesis in their canonical order, with the balances sorted by account.
+type rlpGenesis struct {
+	ChainID     string
+	Time        string
+	Symbol      string
+	Balances    []rlpGenesisBalance
+	Difficulty  uint64
+	BlockReward *uint256.Int
+	Forks       []rlpGenesisFork
+
+	// Mint is left out of the encoding when not set, so the hash of genesis files written before it is unchanged
+	Mint *rlpMintPolicy `rlp:"optional"`
+
+	// Legacy is left out of the encoding when not set, like Mint
+	Legacy *rlpLegacyImport `rlp:"optional"`
+}
+
+type rlpGenesisBalance struct {
+	Account Address
+	Balance *uint256.Int
+}
+
+type rlpGenesisFork struct {
+	Name   string
+	Height uint64
+}
+
+// Hash identifies the chain. It covers the parsed genesis rather than the file, so formatting doesn't change it.
+func (g Genesis) Hash() (Hash, error) {
+	accounts := make([]Address, 0, len(g.Balances))
+	for account := range g.Balances {
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return bytes.Compare(accounts[i][:], accounts[j][:]) < 0
+	})
+
+	balances := make([]rlpGenesisBalance, len(accounts))
+	for i, account := range accounts {
+		balances[i] = rlpGenesisBalance{account, g.Balances[account].uint256()}
+	}
+
+	forks := make([]rlpGenesisFork, 0, len(g.Forks))
+	for _, name := range g.Forks.names() {
+		forks = append(forks, rlpGenesisFork{name, g.Forks[name]})
+	}
+
+	encoded, err := encodeVersioned(EncodingRLPv1, rlpGenesis{
+		ChainID:     g.ChainID,
+		Time:        g.Time.UTC().Format(time.RFC3339Nano),
+		Symbol:      g.Symbol,
+		Balances:    balances,
+		Difficulty:  uint64(g.Difficulty),
+		BlockReward: g.BlockReward.uint256(),
+		Forks:       forks,
+		Mint:        g.Mint.toRLP(),
+		Legacy:      g.Legacy.toRLP(),
+	})
+	if err != nil {
+		return Hash{}, err
+	}
+
+	return sha256.Sum256(encoded), nil
+}
+
+// LoadGenesis reads the genesis of the data dir.
+func LoadGenesis(dataDir string) (Genesis, error) {
+	return loadGenesis(getGenesisJsonFilePath(dataDir))
+}
+
 func loadGenesis(path string) (Genesis, error) {
 	content, err := os.ReadFile(path)
 	if err != nil {
 		return Genesis{}, err
 	}
 
-	var loadedGenesis Genesis
+	// parameters missing from genesis files written before they were introduced keep their former values
+	loadedGenesis := Genesis{Difficulty: DefaultMiningDifficulty, BlockReward: NewAmount(BlockReward)}
 	err = json.Unmarshal(content, &loadedGenesis)
 	if err != nil {
 		return Genesis{}, err
 	}
 
+	if loadedGenesis.Difficulty >= HashLength {
+		return Genesis{}, fmt.Errorf("genesis difficulty %d must be lower than %d", loadedGenesis.Difficulty, HashLength)
+	}
+
+	if loadedGenesis.Forks == nil {
+		loadedGenesis.Forks = make(Forks)
+	}
+
+	if _, ok := loadedGenesis.Forks[ForkTIP1]; !ok {
+		loadedGenesis.Forks[ForkTIP1] = loadedGenesis.ForkTIP1
+	}
+	loadedGenesis.ForkTIP1 = 0
+
+	if err := loadedGenesis.Forks.validate(); err != nil {
+		return Genesis{}, err
+	}
+
+	if _, err := loadedGenesis.Supply(); err != nil {
+		return Genesis{}, err
+	}
+
+	if loadedGenesis.Mint != nil {
+		if err := loadedGenesis.Mint.validate(); err != nil {
+			return Genesis{}, err
+		}
+	}
+
+	if _, ok := loadedGenesis.Forks[ForkTIP2]; ok && loadedGenesis.ChainID == "" {
+		return Genesis{}, fmt.Errorf("fork '%s' requires the genesis to set a chain_id", ForkTIP2)
+	}
+
 	return loadedGenesis, nil
 }
 
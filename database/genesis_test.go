@@ -0,0 +1,66 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestGenesis(t *testing.T, content string) Genesis {
+	path := filepath.Join(t.TempDir(), "genesis.json")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	gen, err := loadGenesis(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return gen
+}
+
+func TestGenesisHash(t *testing.T) {
+	gen := writeTestGenesis(t, genesisJson)
+
+	if gen.ChainID != "the-gamma-ledger" || gen.Time.IsZero() || gen.Difficulty != 3 || gen.BlockReward != NewAmount(100) || gen.Forks[ForkTIP1] != 35 {
+		t.Fatalf("genesis parameters weren't loaded: %+v", gen)
+	}
+
+	hash, err := gen.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the same genesis formatted differently, with the balances in another order
+	reformatted := writeTestGenesis(t, `{"forks":{"tip4":0,"tip1":35},"block_reward":100,"difficulty":3,"symbol":"TGL","chain_id":"the-gamma-ledger",
+		"balances":{"0x0000000000000000000000000000000000000002":1,"0x0000000000000000000000000000000000000001":1000000},
+		"genesis_time":"2023-03-11T01:00:00+01:00"}`)
+
+	reformattedHash, err := reformatted.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reformattedHash != hash {
+		t.Fatalf("genesis hash shouldn't depend on formatting, got %s instead of %s", reformattedHash.Hex(), hash.Hex())
+	}
+
+	staging := gen
+	staging.ChainID = "the-gamma-ledger-staging"
+
+	stagingHash, err := staging.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if stagingHash == hash {
+		t.Fatal("genesis of another chain should have another hash")
+	}
+
+	// genesis files written before the consensus parameters were introduced keep the former values
+	legacy := writeTestGenesis(t, `{"symbol":"TGL","balances":{},"fork_tip_1":35}`)
+	if legacy.Difficulty != DefaultMiningDifficulty || legacy.BlockReward != NewAmount(BlockReward) {
+		t.Fatalf("missing parameters should default to difficulty %d and reward %d, got %d and %s", DefaultMiningDifficulty, BlockReward, legacy.Difficulty, legacy.BlockReward)
+	}
+}
@@ -0,0 +1,373 @@
+package database
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var (
+	indexVersionKey    = []byte("version") // version -> layout version of the index
+	indexTipKey        = []byte("tip")     // tip -> hash + big endian height of the last indexed block
+	indexTxPrefix      = []byte("t")       // t + tx hash -> big endian block height + index in the block
+	indexAddressPrefix = []byte("a")       // a + address + big endian block height + index in the block -> roles
+)
+
+// chainIndexVersion changes whenever the index layout does, so an outdated index is rebuilt.
+const chainIndexVersion = byte(2)
+
+// Roles of an account in an address index entry.
+const (
+	roleFrom = byte(1 << iota)
+	roleTo
+	roleMiner
+)
+
+// blockRewardIndex is the index in the block of the miner reward entry, after every transaction.
+const blockRewardIndex = math.MaxUint32
+
+// chainIndex maps transactions to the main chain block including them,
+// and accounts to the transactions they sent or received and the blocks they mined.
+//
+// It is derived from the block store and kept in sync as blocks are added or switched away from.
+// The last indexed block is recorded so a node only indexes the blocks it doesn't know yet when it starts,
+// and rebuilds the whole index if that block is no longer part of the chain.
+type chainIndex struct {
+	db *leveldb.DB
+}
+
+// txLocation is the position of a transaction in the main chain.
+type txLocation struct {
+	Height uint64
+	Index  int
+}
+
+func openChainIndex(path string) (*chainIndex, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &chainIndex{db}, nil
+}
+
+func indexTxKey(hash Hash) []byte {
+	return append(append([]byte{}, indexTxPrefix...), hash[:]...)
+}
+
+func indexAddressKey(account Address, height uint64, index uint32) []byte {
+	key := append(append([]byte{}, indexAddressPrefix...), account[:]...)
+	key = binary.BigEndian.AppendUint64(key, height)
+
+	return binary.BigEndian.AppendUint32(key, index)
+}
+
+// addressEntries returns the address index entries of the block with the roles of their account.
+func addressEntries(b Block) map[string]byte {
+	entries := make(map[string]byte)
+
+	for i, tx := range b.TXs {
+		entries[string(indexAddressKey(tx.From, b.Header.Number, uint32(i)))] |= roleFrom
+		entries[string(indexAddressKey(tx.To, b.Header.Number, uint32(i)))] |= roleTo
+	}
+
+	// the legacy block has no miner and pays no reward
+	if b.Header.Miner != (Address{}) {
+		entries[string(indexAddressKey(b.Header.Miner, b.Header.Number, blockRewardIndex))] |= roleMiner
+	}
+
+	return entries
+}
+
+// catchUp indexes the stored blocks after the last indexed one.
+func (x *chainIndex) catchUp(store BlockStore) error {
+	from := uint64(0)
+
+	version, err := x.db.Get(indexVersionKey, nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		return err
+	}
+
+	if len(version) != 1 || version[0] != chainIndexVersion {
+		if err := x.clear(); err != nil {
+			return err
+		}
+
+		if err := x.db.Put(indexVersionKey, []byte{chainIndexVersion}, nil); err != nil {
+			return err
+		}
+	}
+
+	tip, err := x.db.Get(indexTipKey, nil)
+	if err != nil && err != leveldb.ErrNotFound {
+		return err
+	}
+
+	if err == nil {
+		tipHash := Hash(tip[:HashLength])
+		tipHeight := binary.BigEndian.Uint64(tip[HashLength:])
+
+		blockFs, err := store.BlockByHeight(tipHeight)
+		if err == nil && blockFs.Key == tipHash {
+			from = tipHeight + 1
+		} else {
+			fmt.Printf("Rebuilding the chain index, block %d '%s' is no longer part of the chain\n", tipHeight, tipHash.Hex())
+			if err := x.clear(); err != nil {
+				return err
+			}
+
+			if err := x.db.Put(indexVersionKey, []byte{chainIndexVersion}, nil); err != nil {
+				return err
+			}
+		}
+	}
+
+	return store.ForEach(from, func(blockFs BlockFS) error {
+		return x.indexBlock(blockFs)
+	})
+}
+
+func (x *chainIndex) clear() error {
+	batch := new(leveldb.Batch)
+
+	it := x.db.NewIterator(nil, nil)
+	for it.Next() {
+		batch.Delete(append([]byte{}, it.Key()...))
+	}
+	it.Release()
+
+	if err := it.Error(); err != nil {
+		return err
+	}
+
+	return x.db.Write(batch, nil)
+}
+
+// indexBlock indexes the transactions of the block and records it as the last indexed block.
+func (x *chainIndex) indexBlock(blockFs BlockFS) error {
+	batch := new(leveldb.Batch)
+
+	for i, tx := range blockFs.Value.TXs {
+		hash, err := tx.Hash()
+		if err != nil {
+			return err
+		}
+
+		location := binary.BigEndian.AppendUint64(nil, blockFs.Value.Header.Number)
+		location = binary.BigEndian.AppendUint32(location, uint32(i))
+		batch.Put(indexTxKey(hash), location)
+	}
+
+	for key, roles := range addressEntries(blockFs.Value) {
+		batch.Put([]byte(key), []byte{roles})
+	}
+
+	batch.Put(indexTipKey, binary.BigEndian.AppendUint64(append([]byte{}, blockFs.Key[:]...), blockFs.Value.Header.Number))
+
+	return x.db.Write(batch, nil)
+}
+
+// unindexBlocks removes the transactions of blocks which left the main chain and records the new last indexed block.
+// An empty tip means no block is indexed anymore.
+func (x *chainIndex) unindexBlocks(blocks []Block, tip BlockFS) error {
+	batch := new(leveldb.Batch)
+
+	for _, block := range blocks {
+		for _, tx := range block.TXs {
+			hash, err := tx.Hash()
+			if err != nil {
+				return err
+			}
+
+			batch.Delete(indexTxKey(hash))
+		}
+
+		for key := range addressEntries(block) {
+			batch.Delete([]byte(key))
+		}
+	}
+
+	if tip.Key.IsEmpty() {
+		batch.Delete(indexTipKey)
+	} else {
+		batch.Put(indexTipKey, binary.BigEndian.AppendUint64(append([]byte{}, tip.Key[:]...), tip.Value.Header.Number))
+	}
+
+	return x.db.Write(batch, nil)
+}
+
+func (x *chainIndex) tx(hash Hash) (txLocation, bool, error) {
+	location, err := x.db.Get(indexTxKey(hash), nil)
+	if err == leveldb.ErrNotFound {
+		return txLocation{}, false, nil
+	}
+	if err != nil {
+		return txLocation{}, false, err
+	}
+
+	return txLocation{binary.BigEndian.Uint64(location), int(binary.BigEndian.Uint32(location[8:]))}, true, nil
+}
+
+func (x *chainIndex) close() error {
+	return x.db.Close()
+}
+
+// TxLookup is a transaction found in the main chain.
+type TxLookup struct {
+	Tx            SignedTx `json:"tx"`
+	BlockHash     Hash     `json:"block_hash"`
+	BlockHeight   uint64   `json:"block_height"`
+	Index         int      `json:"index"`
+	Confirmations uint64   `json:"confirmations"`
+}
+
+// GetTx looks the transaction up in the chain index. It returns false if no main chain block includes it.
+func (s *State) GetTx(hash Hash) (TxLookup, bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	blockFs, index, ok, err := s.findTx(hash)
+	if err != nil || !ok {
+		return TxLookup{}, false, err
+	}
+
+	confirmations := s.latestBlock.Header.Number - blockFs.Value.Header.Number + 1
+
+	return TxLookup{blockFs.Value.TXs[index], blockFs.Key, blockFs.Value.Header.Number, index, confirmations}, true, nil
+}
+
+// findTx returns the main chain block including the transaction with the given hash and its index in the block.
+func (s *State) findTx(hash Hash) (BlockFS, int, bool, error) {
+	location, ok, err := s.index.tx(hash)
+	if err != nil || !ok {
+		return BlockFS{}, 0, false, err
+	}
+
+	blockFs, err := s.store.BlockByHeight(location.Height)
+	if err != nil {
+		return BlockFS{}, 0, false, err
+	}
+
+	if location.Index >= len(blockFs.Value.TXs) {
+		return BlockFS{}, 0, false, fmt.Errorf("chain index points tx '%s' to a missing position of block %d", hash.Hex(), location.Height)
+	}
+
+	txHash, err := blockFs.Value.TXs[location.Index].Hash()
+	if err != nil {
+		return BlockFS{}, 0, false, err
+	}
+
+	// a stale entry of a block which left the main chain
+	if txHash != hash {
+		return BlockFS{}, 0, false, nil
+	}
+
+	return blockFs, location.Index, true, nil
+}
+
+// AccountTx is a transfer touching an account, a transaction it sent or received or the reward of a block it mined.
+type AccountTx struct {
+	BlockHash   Hash      `json:"block_hash"`
+	BlockHeight uint64    `json:"block_height"`
+	BlockTime   uint64    `json:"block_time"`
+	Roles       []string  `json:"roles"`
+	Index       int       `json:"index"` // -1 for the block reward
+	Tx          *SignedTx `json:"tx,omitempty"`
+	Reward      *Amount   `json:"reward,omitempty"`
+}
+
+func roleNames(roles byte) []string {
+	names := make([]string, 0)
+
+	if roles&roleFrom != 0 {
+		names = append(names, "from")
+	}
+
+	if roles&roleTo != 0 {
+		names = append(names, "to")
+	}
+
+	if roles&roleMiner != 0 {
+		names = append(names, "miner")
+	}
+
+	return names
+}
+
+// GetAccountHistory returns the transfers touching the account, newest first, skipping the first offset ones,
+// along with the total number of transfers.
+func (s *State) GetAccountHistory(account Address, offset int, limit int) ([]AccountTx, int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	prefix := append(append([]byte{}, indexAddressPrefix...), account[:]...)
+
+	type entry struct {
+		height uint64
+		index  uint32
+		roles  byte
+	}
+	page := make([]entry, 0)
+	total := 0
+
+	it := s.index.db.NewIterator(util.BytesPrefix(prefix), nil)
+	for ok := it.Last(); ok; ok = it.Prev() {
+		if total >= offset && len(page) < limit {
+			key := it.Key()[len(prefix):]
+			page = append(page, entry{binary.BigEndian.Uint64(key), binary.BigEndian.Uint32(key[8:]), it.Value()[0]})
+		}
+
+		total++
+	}
+	it.Release()
+
+	if err := it.Error(); err != nil {
+		return nil, 0, err
+	}
+
+	txs := make([]AccountTx, 0, len(page))
+	blocks := make(map[uint64]BlockFS)
+
+	for _, e := range page {
+		blockFs, ok := blocks[e.height]
+		if !ok {
+			var err error
+			blockFs, err = s.store.BlockByHeight(e.height)
+			if err != nil {
+				return nil, 0, err
+			}
+			blocks[e.height] = blockFs
+		}
+
+		accountTx := AccountTx{
+			BlockHash:   blockFs.Key,
+			BlockHeight: e.height,
+			BlockTime:   blockFs.Value.Header.Time,
+			Roles:       roleNames(e.roles),
+		}
+
+		if e.index == blockRewardIndex {
+			accountTx.Index = -1
+			reward, err := blockFs.Value.MinerReward(s.RulesAt(e.height))
+			if err != nil {
+				return nil, 0, err
+			}
+			accountTx.Reward = &reward
+		} else {
+			if int(e.index) >= len(blockFs.Value.TXs) {
+				return nil, 0, fmt.Errorf("address index points to a missing tx %d of block %d", e.index, e.height)
+			}
+
+			tx := blockFs.Value.TXs[e.index]
+			accountTx.Index = int(e.index)
+			accountTx.Tx = &tx
+		}
+
+		txs = append(txs, accountTx)
+	}
+
+	return txs, total, nil
+}
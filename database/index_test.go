@@ -0,0 +1,110 @@
+package database
+
+import (
+	"os"
+	"testing"
+)
+
+func TestGetTx(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+
+	state, dataDir := newTestState(t, map[Address]Amount{andrej.addr: NewAmount(1000)})
+
+	var txHashes []Hash
+	for i := uint(1); i <= 3; i++ {
+		tx := andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(100), i, ""))
+		if _, err := state.AddBlock(mineTestBlock(t, state, babaYaga.addr, []SignedTx{tx})); err != nil {
+			t.Fatal(err)
+		}
+
+		txHash, err := tx.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+		txHashes = append(txHashes, txHash)
+	}
+
+	lookup, ok, err := state.GetTx(txHashes[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok || lookup.BlockHeight != 0 || lookup.Index != 0 || lookup.Confirmations != 3 {
+		t.Fatalf("first tx should be found at block 0 with 3 confirmations, got %+v", lookup)
+	}
+
+	if _, err := state.RollbackTo(1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, err := state.GetTx(txHashes[2]); err != nil || ok {
+		t.Fatal("tx of a rolled back block should not be found")
+	}
+	state.Close()
+
+	// a missing index is rebuilt from the block store
+	if err := os.RemoveAll(getChainIndexDirPath(dataDir)); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloaded.Close()
+
+	lookup, ok, err = reloaded.GetTx(txHashes[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok || lookup.BlockHeight != 1 || lookup.Confirmations != 1 {
+		t.Fatalf("second tx should be found at the tip after rebuilding the index, got %+v", lookup)
+	}
+}
+
+func TestGetAccountHistory(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+
+	state, _ := newTestState(t, map[Address]Amount{andrej.addr: NewAmount(1000)})
+
+	for i := uint(1); i <= 3; i++ {
+		tx := andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(100), i, ""))
+		if _, err := state.AddBlock(mineTestBlock(t, state, babaYaga.addr, []SignedTx{tx})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// baba yaga received 3 txs and mined 3 blocks
+	txs, total, err := state.GetAccountHistory(babaYaga.addr, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if total != 6 || len(txs) != 2 {
+		t.Fatalf("expected a page of 2 out of 6 transfers, got %d out of %d", len(txs), total)
+	}
+
+	if txs[0].BlockHeight != 2 || txs[0].Tx == nil || txs[0].Roles[0] != "to" {
+		t.Fatalf("second newest transfer should be the tx received in block 2, got %+v", txs[0])
+	}
+
+	if txs[1].BlockHeight != 1 || txs[1].Tx != nil || *txs[1].Reward != NewAmount(BlockReward+TxGas*TxGasPriceDefault) {
+		t.Fatalf("third newest transfer should be the reward of block 1, got %+v", txs[1])
+	}
+
+	if _, err := state.RollbackTo(0); err != nil {
+		t.Fatal(err)
+	}
+
+	_, total, err = state.GetAccountHistory(andrej.addr, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if total != 1 {
+		t.Fatalf("andrej should have 1 transfer left after the rollback, not %d", total)
+	}
+}
@@ -0,0 +1,139 @@
+package database
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+var (
+	kvBlockPrefix  = []byte("b") // b + hash -> encoded BlockFS
+	kvHeightPrefix = []byte("n") // n + big endian height -> hash
+)
+
+// kvBlockStore keeps blocks in an embedded LevelDB, keyed by hash with a height to hash index,
+// so lookups and syncs don't need to scan the chain.
+type kvBlockStore struct {
+	db *leveldb.DB
+}
+
+func openKVBlockStore(path string) (*kvBlockStore, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kvBlockStore{db}, nil
+}
+
+func kvBlockKey(hash Hash) []byte {
+	return append(append([]byte{}, kvBlockPrefix...), hash[:]...)
+}
+
+func kvHeightKey(height uint64) []byte {
+	key := append([]byte{}, kvHeightPrefix...)
+	return binary.BigEndian.AppendUint64(key, height)
+}
+
+func (s *kvBlockStore) Append(blockFs BlockFS) error {
+	encoded, err := encodeBlockFS(blockFs)
+	if err != nil {
+		return err
+	}
+
+	batch := new(leveldb.Batch)
+	batch.Put(kvBlockKey(blockFs.Key), encoded)
+	batch.Put(kvHeightKey(blockFs.Value.Header.Number), blockFs.Key[:])
+
+	return s.db.Write(batch, &opt.WriteOptions{Sync: true})
+}
+
+func (s *kvBlockStore) BlockByHash(hash Hash) (BlockFS, error) {
+	encoded, err := s.db.Get(kvBlockKey(hash), nil)
+	if err == leveldb.ErrNotFound {
+		return BlockFS{}, fmt.Errorf("invalid hash: '%v', %w", hash.Hex(), errBlockNotFound)
+	}
+	if err != nil {
+		return BlockFS{}, err
+	}
+
+	return decodeBlockFS(encoded)
+}
+
+func (s *kvBlockStore) BlockByHeight(height uint64) (BlockFS, error) {
+	hash, err := s.db.Get(kvHeightKey(height), nil)
+	if err == leveldb.ErrNotFound {
+		return BlockFS{}, fmt.Errorf("invalid height: '%v', %w", height, errBlockNotFound)
+	}
+	if err != nil {
+		return BlockFS{}, err
+	}
+
+	return s.BlockByHash(Hash(hash))
+}
+
+func (s *kvBlockStore) BlocksAfter(hash Hash) ([]Block, error) {
+	blocks := make([]Block, 0)
+
+	from := uint64(0)
+	if !hash.IsEmpty() {
+		blockFs, err := s.BlockByHash(hash)
+		if err != nil {
+			return blocks, nil
+		}
+
+		from = blockFs.Value.Header.Number + 1
+	}
+
+	err := s.ForEach(from, func(blockFs BlockFS) error {
+		blocks = append(blocks, blockFs.Value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return blocks, nil
+}
+
+func (s *kvBlockStore) ForEach(from uint64, fn func(BlockFS) error) error {
+	it := s.db.NewIterator(&util.Range{Start: kvHeightKey(from), Limit: util.BytesPrefix(kvHeightPrefix).Limit}, nil)
+	defer it.Release()
+
+	for it.Next() {
+		blockFs, err := s.BlockByHash(Hash(it.Value()))
+		if err != nil {
+			return err
+		}
+
+		if err := fn(blockFs); err != nil {
+			return err
+		}
+	}
+
+	return it.Error()
+}
+
+func (s *kvBlockStore) TruncateFrom(height uint64) error {
+	batch := new(leveldb.Batch)
+
+	it := s.db.NewIterator(&util.Range{Start: kvHeightKey(height), Limit: util.BytesPrefix(kvHeightPrefix).Limit}, nil)
+	for it.Next() {
+		batch.Delete(append([]byte{}, it.Key()...))
+		batch.Delete(kvBlockKey(Hash(it.Value())))
+	}
+	it.Release()
+
+	if err := it.Error(); err != nil {
+		return err
+	}
+
+	return s.db.Write(batch, &opt.WriteOptions{Sync: true})
+}
+
+func (s *kvBlockStore) Close() error {
+	return s.db.Close()
+}
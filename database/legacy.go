@@ -0,0 +1,290 @@
+package database
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// LegacyTx is a transfer of the tx.db ledger kept before accounts were addresses and transactions were signed.
+type LegacyTx struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Value uint64 `json:"value"`
+	Data  string `json:"data"`
+}
+
+// LegacyGenesis is the old_genesis.json matching the tx.db ledger, with balances of named accounts.
+type LegacyGenesis struct {
+	Time     time.Time         `json:"genesis_time"`
+	ChainID  string            `json:"chain_id"`
+	Balances map[string]uint64 `json:"balances"`
+}
+
+// LegacyImport is the part of a genesis carrying a legacy ledger forward.
+//
+// The genesis starts from the balances of the old genesis and commits to the block 0 holding the legacy transfers,
+// which is valid because of this commitment rather than mined. Legacy transfers being unsigned, any other block 0
+// is rejected, so the history can't be rewritten without changing the genesis hash.
+type LegacyImport struct {
+	// Block is the hash of the block 0 holding the legacy transfers in ledger order
+	Block Hash `json:"block"`
+
+	// Accounts maps every legacy account name to its address
+	Accounts map[string]Address `json:"accounts"`
+}
+
+type rlpLegacyImport struct {
+	Block    Hash
+	Accounts []rlpLegacyAccount
+}
+
+type rlpLegacyAccount struct {
+	Name    string
+	Account Address
+}
+
+// toRLP returns the import with its accounts sorted by name, so their order doesn't change the genesis hash.
+func (l *LegacyImport) toRLP() *rlpLegacyImport {
+	if l == nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(l.Accounts))
+	for name := range l.Accounts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	accounts := make([]rlpLegacyAccount, len(names))
+	for i, name := range names {
+		accounts[i] = rlpLegacyAccount{name, l.Accounts[name]}
+	}
+
+	return &rlpLegacyImport{l.Block, accounts}
+}
+
+// LegacyMigration sums up a migrated legacy ledger.
+type LegacyMigration struct {
+	Genesis  Genesis
+	Block    BlockFS
+	Replayed int
+
+	// Balances are the balances once the legacy block is applied
+	Balances map[Address]Amount
+}
+
+// MigrateLegacyLedger initializes the data dir from the tx.db ledger and old_genesis.json of the legacy dir,
+// mapping every named account to an address.
+//
+// The new genesis holds the balances of the old one, along with the consensus parameters of the default genesis,
+// and block 0 holds every legacy transfer in ledger order, so the history stays part of the chain.
+func MigrateLegacyLedger(legacyDir string, mapping map[string]Address, dataDir string) (LegacyMigration, error) {
+	if fileExist(getGenesisJsonFilePath(dataDir)) {
+		return LegacyMigration{}, fmt.Errorf("data dir '%s' is already initialized", dataDir)
+	}
+
+	seen := make(map[Address]string)
+	for name, account := range mapping {
+		if other, ok := seen[account]; ok {
+			return LegacyMigration{}, fmt.Errorf("legacy accounts '%s' and '%s' are both mapped to '%s'", other, name, account.String())
+		}
+		seen[account] = name
+	}
+
+	content, err := os.ReadFile(filepath.Join(legacyDir, "old_genesis.json"))
+	if err != nil {
+		return LegacyMigration{}, err
+	}
+
+	var legacyGenesis LegacyGenesis
+	if err := json.Unmarshal(content, &legacyGenesis); err != nil {
+		return LegacyMigration{}, fmt.Errorf("invalid legacy genesis: %s", err.Error())
+	}
+
+	var gen Genesis
+	if err := json.Unmarshal([]byte(genesisJson), &gen); err != nil {
+		return LegacyMigration{}, err
+	}
+
+	gen.ChainID = legacyGenesis.ChainID
+	gen.Time = legacyGenesis.Time
+	gen.Balances = make(map[Address]Amount)
+
+	for name, balance := range legacyGenesis.Balances {
+		account, ok := mapping[name]
+		if !ok {
+			return LegacyMigration{}, fmt.Errorf("legacy account '%s' isn't mapped to an address", name)
+		}
+
+		if balance > 0 {
+			gen.Balances[account] = NewAmount(balance)
+		}
+	}
+
+	if _, err := gen.Supply(); err != nil {
+		return LegacyMigration{}, err
+	}
+
+	txs, err := readLegacyLedger(filepath.Join(legacyDir, "tx.db"), mapping)
+	if err != nil {
+		return LegacyMigration{}, err
+	}
+
+	b, balances, err := newLegacyBlock(gen, uint64(legacyGenesis.Time.Unix()), txs)
+	if err != nil {
+		return LegacyMigration{}, err
+	}
+
+	hash, err := b.Hash()
+	if err != nil {
+		return LegacyMigration{}, err
+	}
+
+	gen.Legacy = &LegacyImport{hash, mapping}
+
+	content, err = json.MarshalIndent(gen, "", "\t")
+	if err != nil {
+		return LegacyMigration{}, err
+	}
+
+	if err := InitDataDirIfNotExists(dataDir, content); err != nil {
+		return LegacyMigration{}, err
+	}
+
+	// the block is added like any other, so the data dir is only left initialized if the chain loads
+	state, err := NewStateFromDisk(dataDir)
+	if err == nil {
+		_, err = state.AddBlock(b)
+		state.Close()
+	}
+	if err != nil {
+		os.RemoveAll(getDatabaseDirPath(dataDir))
+		return LegacyMigration{}, err
+	}
+
+	return LegacyMigration{gen, BlockFS{hash, b}, len(txs), balances}, nil
+}
+
+// readLegacyLedger returns the transfers of the tx.db ledger as txs between the mapped addresses.
+//
+// Their nonce is their position in the ledger, which tells apart identical transfers.
+func readLegacyLedger(path string, mapping map[string]Address) ([]SignedTx, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	txs := make([]SignedTx, 0)
+
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var legacyTx LegacyTx
+		if err := json.Unmarshal(scanner.Bytes(), &legacyTx); err != nil {
+			return nil, fmt.Errorf("invalid legacy tx %d: %s", len(txs)+1, err.Error())
+		}
+
+		from, ok := mapping[legacyTx.From]
+		if !ok {
+			return nil, fmt.Errorf("legacy account '%s' isn't mapped to an address", legacyTx.From)
+		}
+
+		to, ok := mapping[legacyTx.To]
+		if !ok {
+			return nil, fmt.Errorf("legacy account '%s' isn't mapped to an address", legacyTx.To)
+		}
+
+		tx := Tx{From: from, To: to, Value: NewAmount(legacyTx.Value), Nonce: uint(len(txs) + 1), Data: legacyTx.Data, Version: CurrentEncoding}
+		txs = append(txs, NewSignedTx(tx, nil))
+	}
+
+	return txs, scanner.Err()
+}
+
+// newLegacyBlock returns the block 0 holding the legacy transfers, committing to the state they result in,
+// and the balances of that state.
+func newLegacyBlock(gen Genesis, blockTime uint64, txs []SignedTx) (Block, map[Address]Amount, error) {
+	b := NewBlock(Hash{}, 0, 0, blockTime, Address{}, txs)
+
+	state := newGenesisState("", gen, nil, gen.Difficulty)
+	if err := applyLegacyBlock(b, state); err != nil {
+		return Block{}, nil, err
+	}
+	b.Header.StateRoot = state.stateRoot()
+
+	return b, state.Balances, nil
+}
+
+// checkLegacyBlock tells if the block is the block 0 the genesis commits to, failing if the genesis commits
+// to another one.
+func (s *State) checkLegacyBlock(b Block, hash Hash) (bool, error) {
+	if s.legacyBlock.IsEmpty() || b.Header.Number != 0 {
+		return false, nil
+	}
+
+	if hash != s.legacyBlock {
+		return false, fmt.Errorf("block 0 must be the legacy block '%s' the genesis commits to, not '%s'", s.legacyBlock.Hex(), hash.Hex())
+	}
+
+	return true, nil
+}
+
+// applyLegacyBlock applies the transfers of the legacy block, in ledger order.
+//
+// They're unsigned and pay no fee, a mint credits the recipient without debiting the sender, and the block
+// pays no miner reward. Their nonces are positions in the ledger, not account nonces.
+func applyLegacyBlock(b Block, s *State) error {
+	txRoot, err := TxMerkleRoot(b.TXs)
+	if err != nil {
+		return err
+	}
+
+	if txRoot != b.Header.TxRoot {
+		return fmt.Errorf("block tx root must be '%s' not '%s'", txRoot.Hex(), b.Header.TxRoot.Hex())
+	}
+
+	minted := NewAmount(0)
+
+	for i, tx := range b.TXs {
+		if tx.IsMint() {
+			if minted, err = minted.Add(tx.Value); err != nil {
+				return fmt.Errorf("legacy tx %d: supply overflows: %s", i+1, err.Error())
+			}
+		} else {
+			fromBalance, err := s.Balances[tx.From].Sub(tx.Value)
+			if err != nil {
+				return fmt.Errorf("legacy tx %d: '%s' can't send %s, its balance is %s", i+1, tx.From.String(), tx.Value, s.Balances[tx.From])
+			}
+			s.Balances[tx.From] = fromBalance
+		}
+
+		toBalance, err := s.Balances[tx.To].Add(tx.Value)
+		if err != nil {
+			return fmt.Errorf("legacy tx %d: '%s' balance overflows: %s", i+1, tx.To.String(), err.Error())
+		}
+		s.Balances[tx.To] = toBalance
+	}
+
+	s.supply, err = s.supply.withBlock(NewAmount(0), NewAmount(0), minted)
+	if err != nil {
+		return err
+	}
+
+	if !b.Header.StateRoot.IsEmpty() {
+		stateRoot := s.stateRoot()
+		if stateRoot != b.Header.StateRoot {
+			return fmt.Errorf("block state root must be '%s' not '%s'", stateRoot.Hex(), b.Header.StateRoot.Hex())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package database
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateLegacyLedger(t *testing.T) {
+	legacyDir := t.TempDir()
+
+	oldGenesis := `{"genesis_time": "2023-03-11T00:00:00.000000000Z", "chain_id": "the-gamma-ledger", "balances": {"admin": 1000, "user1": 100}}`
+	txDb := `{"from":"admin","to":"user1","value":100,"data":""}
+{"from":"user1","to":"user2","value":200,"data":""}
+{"from":"admin","to":"admin","value":10,"data":"mint"}
+`
+
+	if err := os.WriteFile(filepath.Join(legacyDir, "old_genesis.json"), []byte(oldGenesis), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(legacyDir, "tx.db"), []byte(txDb), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	admin, user1, user2 := NewAccount("0x01"), NewAccount("0x02"), NewAccount("0x03")
+
+	if _, err := MigrateLegacyLedger(legacyDir, map[string]Address{"admin": admin, "user1": user1}, t.TempDir()); err == nil {
+		t.Fatal("migration should fail while user2 isn't mapped")
+	}
+
+	dataDir := t.TempDir()
+	migration, err := MigrateLegacyLedger(legacyDir, map[string]Address{"admin": admin, "user1": user1, "user2": user2}, dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the genesis holds the old balances and block 0 the legacy transfers
+	if migration.Replayed != 3 || len(migration.Genesis.Balances) != 2 || migration.Genesis.Balances[admin] != NewAmount(1000) || migration.Genesis.Legacy.Block != migration.Block.Key {
+		t.Fatalf("genesis should hold the old balances and commit to the legacy block, got %d txs and %v", migration.Replayed, migration.Genesis.Balances)
+	}
+
+	state, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// user1 spent all its balance
+	if state.LatestBlockHash() != migration.Block.Key || len(state.LatestBlock().TXs) != 3 || state.Balances[admin] != NewAmount(910) || state.Balances[user1] != NewAmount(0) || state.Balances[user2] != NewAmount(200) {
+		t.Fatalf("legacy transfers should leave admin 910 and user2 200, got %v", state.Balances)
+	}
+
+	supply := state.Supply()
+	if supply.Circulating != NewAmount(1110) || supply.Minted != NewAmount(10) || supply.Rewarded != NewAmount(0) || state.chainID != "the-gamma-ledger" {
+		t.Fatalf("only the legacy mint should add to the old genesis supply, got %+v", supply)
+	}
+	state.Close()
+
+	if verified, err := VerifyChain(dataDir); err != nil || verified != 1 {
+		t.Fatalf("legacy block should verify, got %d blocks and %v", verified, err)
+	}
+
+	// a node of the same genesis only accepts the legacy block it commits to
+	genesis, err := os.ReadFile(getGenesisJsonFilePath(dataDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	peerDir := t.TempDir()
+	if err := InitDataDirIfNotExists(peerDir, genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	peer, err := NewStateFromDisk(peerDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer peer.Close()
+
+	forged := migration.Block.Value
+	forged.TXs = append([]SignedTx{}, forged.TXs...)
+	forged.TXs[1].Value = NewAmount(100)
+	forged.Header.TxRoot, _ = TxMerkleRoot(forged.TXs)
+
+	if _, err := peer.AddBlock(forged); err == nil {
+		t.Fatal("legacy block other than the one of the genesis should be rejected")
+	}
+
+	if _, err := peer.AddBlock(migration.Block.Value); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MigrateLegacyLedger(legacyDir, map[string]Address{"admin": admin, "user1": user1, "user2": user2}, dataDir); err == nil {
+		t.Fatal("migration should not overwrite an initialized data dir")
+	}
+}
@@ -0,0 +1,128 @@
+package database
+
+import (
+	"crypto/sha256"
+	"fmt"
+)
+
+// Leaves and inner nodes are hashed with a different prefix so an inner node can't be passed off as a leaf.
+const (
+	merkleLeafPrefix = byte(0)
+	merkleNodePrefix = byte(1)
+)
+
+// MerkleNode is one step of a Merkle inclusion proof: the sibling hash and on which side of the path it is.
+type MerkleNode struct {
+	Hash Hash `json:"hash"`
+	Left bool `json:"left"`
+}
+
+func merkleLeaf(h Hash) Hash {
+	return sha256.Sum256(append([]byte{merkleLeafPrefix}, h[:]...))
+}
+
+func merkleParent(left, right Hash) Hash {
+	return sha256.Sum256(append(append([]byte{merkleNodePrefix}, left[:]...), right[:]...))
+}
+
+// merkleLevels returns every level of the tree over the leaves, from the hashed leaves up to the root.
+//
+// A node without a sibling is promoted to the next level unchanged, so no leaf is ever duplicated.
+func merkleLevels(leaves []Hash) [][]Hash {
+	level := make([]Hash, len(leaves))
+	for i, leaf := range leaves {
+		level[i] = merkleLeaf(leaf)
+	}
+
+	levels := [][]Hash{level}
+	for len(level) > 1 {
+		next := make([]Hash, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				continue
+			}
+
+			next = append(next, merkleParent(level[i], level[i+1]))
+		}
+
+		levels = append(levels, next)
+		level = next
+	}
+
+	return levels
+}
+
+// MerkleRoot returns the root of the Merkle tree over the leaves. The root of no leaves is the hash of nothing.
+func MerkleRoot(leaves []Hash) Hash {
+	if len(leaves) == 0 {
+		return sha256.Sum256(nil)
+	}
+
+	levels := merkleLevels(leaves)
+
+	return levels[len(levels)-1][0]
+}
+
+// MerkleProof returns the sibling path proving the leaf at index is part of the tree over the leaves.
+func MerkleProof(leaves []Hash, index int) ([]MerkleNode, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, fmt.Errorf("leaf %d is out of the %d leaves", index, len(leaves))
+	}
+
+	levels := merkleLevels(leaves)
+	proof := make([]MerkleNode, 0, len(levels)-1)
+
+	for _, level := range levels[:len(levels)-1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof = append(proof, MerkleNode{level[sibling], sibling < index})
+		}
+
+		index /= 2
+	}
+
+	return proof, nil
+}
+
+// VerifyMerkleProof checks the proof leads from the leaf to the root.
+func VerifyMerkleProof(root Hash, leaf Hash, proof []MerkleNode) bool {
+	h := merkleLeaf(leaf)
+
+	for _, node := range proof {
+		if node.Left {
+			h = merkleParent(node.Hash, h)
+		} else {
+			h = merkleParent(h, node.Hash)
+		}
+	}
+
+	return h == root
+}
+
+// TxMerkleRoot returns the Merkle root over the signed hashes of the transactions, in block order.
+//
+// The leaves cover the signatures, so a signature can't be swapped without changing the block hash.
+func TxMerkleRoot(txs []SignedTx) (Hash, error) {
+	leaves, err := txLeaves(txs)
+	if err != nil {
+		return Hash{}, err
+	}
+
+	return MerkleRoot(leaves), nil
+}
+
+func txLeaves(txs []SignedTx) ([]Hash, error) {
+	hashes := make([]Hash, len(txs))
+
+	for i, tx := range txs {
+		hash, err := tx.signedHash()
+		if err != nil {
+			return nil, err
+		}
+
+		hashes[i] = hash
+	}
+
+	return hashes, nil
+}
@@ -0,0 +1,141 @@
+package database
+
+import (
+	"crypto/sha256"
+	"testing"
+)
+
+func TestMerkleProof(t *testing.T) {
+	for size := 1; size <= 9; size++ {
+		leaves := make([]Hash, size)
+		for i := range leaves {
+			leaves[i] = sha256.Sum256([]byte{byte(i)})
+		}
+
+		root := MerkleRoot(leaves)
+
+		for i := range leaves {
+			proof, err := MerkleProof(leaves, i)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !VerifyMerkleProof(root, leaves[i], proof) {
+				t.Fatalf("proof of leaf %d out of %d should be valid", i, size)
+			}
+
+			if VerifyMerkleProof(root, sha256.Sum256([]byte("forged")), proof) {
+				t.Fatalf("proof of leaf %d out of %d should not be valid for another leaf", i, size)
+			}
+		}
+	}
+
+	if MerkleRoot(nil).IsEmpty() {
+		t.Fatal("root of no leaves must not be the empty hash, which marks blocks without a tx root")
+	}
+}
+
+func TestGetTxProof(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+
+	state, _ := newTestState(t, map[Address]Amount{andrej.addr: NewAmount(1000)})
+
+	var txs []SignedTx
+	for i := uint(1); i <= 3; i++ {
+		txs = append(txs, andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(10), i, "")))
+	}
+
+	block := mineTestBlock(t, state, babaYaga.addr, txs)
+	if _, err := state.AddBlock(block); err != nil {
+		t.Fatal(err)
+	}
+
+	txHash, err := txs[2].Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	proof, err := state.GetTxProof(txHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := proof.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headerHash, err := proof.Header.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ok || proof.Index != 2 || headerHash != proof.BlockHash {
+		t.Fatal("proof should verify against the header of the block including the tx")
+	}
+}
+
+func TestTxMerkleRootCoversSignature(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+
+	tx := andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(10), 1, ""))
+
+	swapped := tx
+	swapped.Sig = append([]byte{}, tx.Sig...)
+	swapped.Sig[0] ^= 0xff
+
+	root, err := TxMerkleRoot([]SignedTx{tx})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	swappedRoot, err := TxMerkleRoot([]SignedTx{swapped})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if root == swappedRoot {
+		t.Fatal("tx root should change along with a tx signature")
+	}
+}
+
+func TestAddBlockRejectsSwappedPayload(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+
+	state, _ := newTestState(t, map[Address]Amount{andrej.addr: NewAmount(1000)})
+
+	tx := andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(10), 1, ""))
+	other := andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(20), 1, ""))
+
+	mined := mineTestBlock(t, state, babaYaga.addr, []SignedTx{tx})
+	minedHash, _ := mined.Hash()
+
+	swapped := mined
+	swapped.TXs = []SignedTx{other}
+
+	// the binary header is hashed alone, the payload can be swapped without changing the hash
+	if hash, _ := swapped.Hash(); hash != minedHash {
+		t.Fatal("swapping the payload shouldn't change the hash of a binary header")
+	}
+
+	if _, err := state.AddBlock(swapped); err == nil {
+		t.Fatal("block whose payload doesn't match its tx root should be rejected")
+	}
+
+	// without a tx root, nothing would tie the payload to the block hash
+	uncommitted := mineTestBlock(t, state, babaYaga.addr, []SignedTx{tx})
+	uncommitted.Header.TxRoot = Hash{}
+	uncommitted = sealTestBlock(t, uncommitted)
+	uncommitted.TXs = []SignedTx{other}
+
+	if _, err := state.AddBlock(uncommitted); err == nil {
+		t.Fatal("binary block without a tx root should be rejected")
+	}
+
+	if _, err := state.AddBlock(mined); err != nil {
+		t.Fatal(err)
+	}
+}
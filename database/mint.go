@@ -0,0 +1,142 @@
+package database
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/holiman/uint256"
+)
+
+// MintPolicy lets the genesis authorize accounts to create tokens with mint transactions.
+//
+// A mint tx is a tx whose data is "mint", signed by an authority. It credits its value to the recipient
+// without debiting the authority, which only pays the fee. Chains whose genesis sets no policy have no mint
+// transactions, a tx with the "mint" data is a plain transfer.
+type MintPolicy struct {
+	Authorities []Address `json:"authorities"`
+
+	// Cap is the most that can be minted per period, unlimited if zero
+	Cap Amount `json:"cap"`
+
+	// Period is the number of blocks the cap applies to, starting at height 0, the whole chain if zero
+	Period uint64 `json:"period"`
+}
+
+// MintLedger tracks the tokens created by mint transactions.
+type MintLedger struct {
+	Total        Amount `json:"total"`
+	Period       uint64 `json:"period"`
+	PeriodMinted Amount `json:"period_minted"`
+}
+
+type rlpMintPolicy struct {
+	Authorities []Address
+	Cap         *uint256.Int
+	Period      uint64
+}
+
+func (p *MintPolicy) validate() error {
+	if len(p.Authorities) == 0 {
+		return fmt.Errorf("genesis mint policy must list at least one authority")
+	}
+
+	return nil
+}
+
+// toRLP returns the policy with its authorities sorted, so their order doesn't change the genesis hash.
+func (p *MintPolicy) toRLP() *rlpMintPolicy {
+	if p == nil {
+		return nil
+	}
+
+	authorities := append([]Address{}, p.Authorities...)
+	sort.Slice(authorities, func(i, j int) bool {
+		return bytes.Compare(authorities[i][:], authorities[j][:]) < 0
+	})
+
+	return &rlpMintPolicy{authorities, p.Cap.uint256(), p.Period}
+}
+
+func (p *MintPolicy) IsAuthority(account Address) bool {
+	for _, authority := range p.Authorities {
+		if authority == account {
+			return true
+		}
+	}
+
+	return false
+}
+
+// period returns the cap period of the block at the given height.
+func (p *MintPolicy) period(height uint64) uint64 {
+	if p.Period == 0 {
+		return 0
+	}
+
+	return height / p.Period
+}
+
+// isMintTx tells if the tx mints tokens on this chain.
+func (s *State) isMintTx(tx Tx) bool {
+	return s.mintPolicy != nil && tx.IsMint()
+}
+
+// mintedWith returns the mint ledger once the mint tx is included in the next block,
+// failing if the tx isn't signed by an authority or exceeds the cap.
+func (s *State) mintedWith(tx Tx) (MintLedger, error) {
+	if !s.mintPolicy.IsAuthority(tx.From) {
+		return MintLedger{}, fmt.Errorf("wrong TX. Sender '%s' is not a mint authority", tx.From.String())
+	}
+
+	ledger := s.minted
+
+	period := s.mintPolicy.period(s.nextBlockNumber())
+	if period != ledger.Period {
+		ledger.Period = period
+		ledger.PeriodMinted = Amount{}
+	}
+
+	periodMinted, err := ledger.PeriodMinted.Add(tx.Value)
+	if err != nil {
+		return MintLedger{}, fmt.Errorf("wrong TX. Minted supply overflows: %s", err.Error())
+	}
+
+	if !s.mintPolicy.Cap.IsZero() && periodMinted.Cmp(s.mintPolicy.Cap) > 0 {
+		return MintLedger{}, fmt.Errorf("wrong TX. Minting %s TBB exceeds the cap of %s TBB per period, %s TBB are already minted", tx.Value, s.mintPolicy.Cap, ledger.PeriodMinted)
+	}
+
+	total, err := ledger.Total.Add(tx.Value)
+	if err != nil {
+		return MintLedger{}, fmt.Errorf("wrong TX. Minted supply overflows: %s", err.Error())
+	}
+
+	ledger.PeriodMinted = periodMinted
+	ledger.Total = total
+
+	return ledger, nil
+}
+
+// MintPolicy returns the mint policy of the genesis, nil if the chain has none.
+func (s *State) MintPolicy() *MintPolicy {
+	return s.mintPolicy
+}
+
+// Minted returns the tokens created by mint transactions up to the latest block,
+// and those of the cap period of the next block.
+func (s *State) Minted() MintLedger {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ledger := s.minted
+
+	if s.mintPolicy != nil {
+		period := s.mintPolicy.period(s.nextBlockNumber())
+		if period != ledger.Period {
+			ledger.Period = period
+			ledger.PeriodMinted = Amount{}
+		}
+	}
+
+	return ledger
+}
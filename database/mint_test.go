@@ -0,0 +1,82 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestMint(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+	miner := newTestAccount(t)
+
+	state, dataDir := newTestStateFromGenesis(t, Genesis{
+		ChainID:     "gamma-test",
+		Symbol:      "TGL",
+		Balances:    map[Address]Amount{andrej.addr: NewAmount(1000), babaYaga.addr: NewAmount(1000)},
+		Difficulty:  testMiningDifficulty,
+		BlockReward: NewAmount(BlockReward),
+		Mint:        &MintPolicy{Authorities: []Address{andrej.addr}, Cap: NewAmount(100), Period: 2},
+	})
+
+	mint := func(from testAccount, value uint64, nonce uint) SignedTx {
+		return from.sign(t, NewBaseTx(from.addr, babaYaga.addr, NewAmount(value), nonce, "mint"))
+	}
+
+	if _, err := state.AddBlock(mineTestBlock(t, state, miner.addr, []SignedTx{mint(babaYaga, 10, 1)})); err == nil {
+		t.Fatal("mint signed by an account which isn't an authority should be rejected")
+	}
+
+	if _, err := state.AddBlock(mineTestBlock(t, state, miner.addr, []SignedTx{mint(andrej, 60, 1), mint(andrej, 50, 2)})); err == nil {
+		t.Fatal("mints exceeding the cap of the period should be rejected")
+	}
+
+	if _, err := state.AddBlock(mineTestBlock(t, state, miner.addr, []SignedTx{mint(andrej, 60, 1)})); err != nil {
+		t.Fatal(err)
+	}
+
+	// the authority only pays the fee, the minted value is new money
+	fee := uint64(TxGas * TxGasPriceDefault)
+	if state.Balances[andrej.addr] != NewAmount(1000-fee) || state.Balances[babaYaga.addr] != NewAmount(1060) {
+		t.Fatalf("mint should credit baba yaga without debiting andrej, got %s and %s", state.Balances[babaYaga.addr], state.Balances[andrej.addr])
+	}
+
+	if _, err := state.AddBlock(mineTestBlock(t, state, miner.addr, []SignedTx{mint(andrej, 50, 2)})); err == nil {
+		t.Fatal("mint exceeding the cap of the period should be rejected")
+	}
+
+	// block 2 starts a new period
+	if _, err := state.AddBlock(mineTestBlock(t, state, miner.addr, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := state.AddBlock(mineTestBlock(t, state, miner.addr, []SignedTx{mint(andrej, 50, 2)})); err != nil {
+		t.Fatal(err)
+	}
+
+	minted := state.Minted()
+	if minted.Total != NewAmount(110) || minted.Period != 1 || minted.PeriodMinted != NewAmount(50) {
+		t.Fatalf("110 TGL should be minted with 50 in period 1, got %+v", minted)
+	}
+
+	if _, err := VerifyChain(dataDir); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := state.RollbackTo(0); err != nil {
+		t.Fatal(err)
+	}
+
+	if minted := state.Minted(); minted.Total != NewAmount(60) || minted.PeriodMinted != NewAmount(60) {
+		t.Fatalf("rollback to block 0 should leave 60 TGL minted, got %+v", minted)
+	}
+
+	// without a mint policy, a tx with the mint data is a plain transfer
+	plain, _ := newTestState(t, map[Address]Amount{andrej.addr: NewAmount(1000)})
+	if _, err := plain.AddBlock(mineTestBlock(t, plain, miner.addr, []SignedTx{mint(andrej, 60, 1)})); err != nil {
+		t.Fatal(err)
+	}
+
+	if plain.Balances[andrej.addr] != NewAmount(1000-60-fee) || !plain.Minted().Total.IsZero() {
+		t.Fatalf("mint data should only transfer on chains without a mint policy, andrej has %s", plain.Balances[andrej.addr])
+	}
+}
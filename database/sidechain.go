@@ -0,0 +1,511 @@
+package database
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+)
+
+// MaxSideBlocks is the number of side blocks kept. Past it, the lowest side blocks are pruned with their descendants,
+// they're the furthest from overtaking the main chain.
+const MaxSideBlocks = 1024
+
+// sideChain keeps valid blocks which are not part of the main chain, either competing branches
+// which may still overtake it or blocks the main chain switched away from, keyed by hash.
+type sideChain struct {
+	db *leveldb.DB
+
+	// blocks are the parent and height of every side block, to find descendants without reading the blocks
+	blocks map[Hash]sideBlockRef
+}
+
+type sideBlockRef struct {
+	parent Hash
+	number uint64
+}
+
+func openSideChain(path string) (*sideChain, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &sideChain{db, make(map[Hash]sideBlockRef)}
+
+	it := db.NewIterator(nil, nil)
+	defer it.Release()
+
+	for it.Next() {
+		blockFs, err := decodeBlockFS(it.Value())
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+
+		c.blocks[blockFs.Key] = sideBlockRef{blockFs.Value.Header.Parent, blockFs.Value.Header.Number}
+	}
+
+	if err := it.Error(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *sideChain) put(blockFs BlockFS) error {
+	encoded, err := encodeBlockFS(blockFs)
+	if err != nil {
+		return err
+	}
+
+	if err := c.db.Put(blockFs.Key[:], encoded, &opt.WriteOptions{Sync: true}); err != nil {
+		return err
+	}
+
+	c.blocks[blockFs.Key] = sideBlockRef{blockFs.Value.Header.Parent, blockFs.Value.Header.Number}
+
+	return nil
+}
+
+func (c *sideChain) get(hash Hash) (BlockFS, bool, error) {
+	var blockFs BlockFS
+
+	encoded, err := c.db.Get(hash[:], nil)
+	if err == leveldb.ErrNotFound {
+		return blockFs, false, nil
+	}
+	if err != nil {
+		return blockFs, false, err
+	}
+
+	blockFs, err = decodeBlockFS(encoded)
+	if err != nil {
+		return blockFs, false, err
+	}
+
+	return blockFs, true, nil
+}
+
+func (c *sideChain) delete(hash Hash) error {
+	if err := c.db.Delete(hash[:], &opt.WriteOptions{Sync: true}); err != nil {
+		return err
+	}
+
+	delete(c.blocks, hash)
+
+	return nil
+}
+
+// deleteSubtree removes the side block with the given hash along with every side block descending from it.
+func (c *sideChain) deleteSubtree(hash Hash) error {
+	subtree := map[Hash]bool{hash: true}
+
+	// a descendant is added once its parent is, repeat until a pass finds no new one
+	for found := true; found; {
+		found = false
+		for h, ref := range c.blocks {
+			if subtree[ref.parent] && !subtree[h] {
+				subtree[h] = true
+				found = true
+			}
+		}
+	}
+
+	batch := new(leveldb.Batch)
+	for h := range subtree {
+		batch.Delete(h[:])
+	}
+
+	if err := c.db.Write(batch, &opt.WriteOptions{Sync: true}); err != nil {
+		return err
+	}
+
+	for h := range subtree {
+		delete(c.blocks, h)
+	}
+
+	return nil
+}
+
+// prune removes the lowest side blocks and their descendants until at most limit are left.
+// The kept blocks are never removed, nor are their ancestors as long as the kept blocks include them.
+func (c *sideChain) prune(limit int, keep []BlockFS) error {
+	kept := make(map[Hash]bool)
+	for _, blockFs := range keep {
+		kept[blockFs.Key] = true
+	}
+
+	for len(c.blocks) > limit {
+		lowest := Hash{}
+		found := false
+
+		for h, ref := range c.blocks {
+			if !kept[h] && (!found || ref.number < c.blocks[lowest].number) {
+				lowest = h
+				found = true
+			}
+		}
+
+		if !found {
+			return nil
+		}
+
+		if err := c.deleteSubtree(lowest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *sideChain) close() error {
+	return c.db.Close()
+}
+
+// reorgJournal records a branch switch in progress, so one interrupted once the main chain was truncated
+// is completed when the state is loaded again. The blocks of the branch are kept as side blocks until appended.
+type reorgJournal struct {
+	ForkHeight uint64 `json:"fork_height"`
+	Branch     []Hash `json:"branch"`
+}
+
+func writeReorgJournal(dataDir string, journal reorgJournal) error {
+	journalJson, err := json.Marshal(journal)
+	if err != nil {
+		return err
+	}
+
+	path := getReorgJournalFilePath(dataDir)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, journalJson, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+func readReorgJournal(dataDir string) (reorgJournal, bool, error) {
+	var journal reorgJournal
+
+	content, err := os.ReadFile(getReorgJournalFilePath(dataDir))
+	if os.IsNotExist(err) {
+		return journal, false, nil
+	}
+	if err != nil {
+		return journal, false, err
+	}
+
+	if err := json.Unmarshal(content, &journal); err != nil {
+		return journal, false, fmt.Errorf("invalid reorg journal: %s", err.Error())
+	}
+
+	return journal, true, nil
+}
+
+// ChainUpdate describes how importing a block changed the main chain.
+type ChainUpdate struct {
+	// Removed are the blocks which left the main chain for a side chain, ordered by height
+	Removed []Block
+
+	// Added are the blocks which joined the main chain, ordered by height
+	Added []Block
+}
+
+// IsReorg tells if blocks were switched away from the main chain.
+func (u ChainUpdate) IsReorg() bool {
+	return len(u.Removed) > 0
+}
+
+// ImportBlock adds a block received from the network, which doesn't have to extend the main chain.
+//
+// A block extending the tip is added to the main chain. A block forking off the main chain or extending
+// a side chain is stored as a side block, and once its branch took more work than the main chain blocks
+// after the common ancestor, the state is rolled back to the common ancestor and the branch is applied instead.
+//
+// The work of a block follows from the difficulty it was mined at, see blockWork, so a shorter branch mined
+// at a higher difficulty may overtake a longer one. Syncing with peers compares the total work the same way.
+//
+// The block parent must be known, either as a main chain or as a side block.
+func (s *State) ImportBlock(b Block) (ChainUpdate, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	hash, err := b.Hash()
+	if err != nil {
+		return ChainUpdate{}, err
+	}
+
+	if _, err := s.store.BlockByHash(hash); err == nil {
+		return ChainUpdate{}, nil
+	}
+
+	if b.Header.Parent == s.latestBlockHash && b.Header.Number == s.nextBlockNumber() {
+		if _, err := s.addBlock(b); err != nil {
+			return ChainUpdate{}, err
+		}
+
+		return ChainUpdate{Added: []Block{b}}, nil
+	}
+
+	if !IsBlockHashValid(hash, s.miningDifficulty) {
+		return ChainUpdate{}, fmt.Errorf("invalid block hash %x", hash)
+	}
+
+	branch, err := s.branchOf(b)
+	if err != nil {
+		return ChainUpdate{}, err
+	}
+
+	branch = append(branch, BlockFS{hash, b})
+
+	if err := s.side.put(BlockFS{hash, b}); err != nil {
+		return ChainUpdate{}, err
+	}
+
+	if err := s.side.prune(MaxSideBlocks, branch); err != nil {
+		return ChainUpdate{}, err
+	}
+
+	if s.hasGenesisBlock {
+		outworks, err := s.outworksMainChain(branch)
+		if err != nil {
+			return ChainUpdate{}, err
+		}
+
+		if !outworks {
+			fmt.Printf("Stored side block %d '%s', the main chain took at least as much work\n", b.Header.Number, hash.Hex())
+			return ChainUpdate{}, nil
+		}
+	}
+
+	return s.switchToBranch(branch)
+}
+
+// outworksMainChain tells if the branch took more work than the main chain blocks it would replace.
+// On a tie the main chain is kept, it was seen first.
+func (s *State) outworksMainChain(branch []BlockFS) (bool, error) {
+	branchWork := new(big.Int)
+	for _, blockFs := range branch {
+		branchWork.Add(branchWork, blockWork(blockFs.Key))
+	}
+
+	mainWork := new(big.Int)
+	err := s.store.ForEach(branch[0].Value.Header.Number, func(blockFs BlockFS) error {
+		mainWork.Add(mainWork, blockWork(blockFs.Key))
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return branchWork.Cmp(mainWork) > 0, nil
+}
+
+// branchOf returns the side blocks between the main chain and the block, ordered by height.
+func (s *State) branchOf(b Block) ([]BlockFS, error) {
+	branch := make([]BlockFS, 0)
+	child := b
+
+	for {
+		if child.Header.Parent.IsEmpty() {
+			if child.Header.Number != 0 {
+				return nil, fmt.Errorf("block %d has no parent", child.Header.Number)
+			}
+
+			return branch, nil
+		}
+
+		parent, err := s.store.BlockByHash(child.Header.Parent)
+		inMainChain := err == nil
+		if !inMainChain {
+			var ok bool
+			parent, ok, err = s.side.get(child.Header.Parent)
+			if err != nil {
+				return nil, err
+			}
+
+			if !ok {
+				return nil, fmt.Errorf("parent '%s' of block %d is unknown", child.Header.Parent.Hex(), child.Header.Number)
+			}
+		}
+
+		if child.Header.Number != parent.Value.Header.Number+1 {
+			return nil, fmt.Errorf("block %d can't follow its parent block %d", child.Header.Number, parent.Value.Header.Number)
+		}
+
+		if inMainChain {
+			return branch, nil
+		}
+
+		branch = append([]BlockFS{parent}, branch...)
+		child = parent.Value
+	}
+}
+
+// switchToBranch rolls the state back to right before the first block of the branch and applies the branch.
+//
+// The branch is validated before anything is changed, the replaced main chain blocks are kept as side blocks.
+// The switch is journaled before the main chain is truncated, so an interrupted one is completed on the next load,
+// and an invalid branch block is pruned along with every side block descending from it.
+func (s *State) switchToBranch(branch []BlockFS) (ChainUpdate, error) {
+	forkHeight := branch[0].Value.Header.Number
+
+	var pendingState *State
+	var err error
+	if forkHeight == 0 {
+		pendingState, err = s.genesisState()
+	} else {
+		pendingState, err = s.revertedTo(forkHeight - 1)
+	}
+	if err != nil {
+		return ChainUpdate{}, err
+	}
+
+	undos := make([]blockUndo, 0, len(branch))
+	for _, blockFs := range branch {
+		before := pendingState.Copy()
+
+		if err := applyBlock(blockFs.Value, pendingState); err != nil {
+			if pruneErr := s.side.deleteSubtree(blockFs.Key); pruneErr != nil {
+				fmt.Printf("ERROR: unable to prune the invalid branch. %s\n", pruneErr.Error())
+			}
+
+			return ChainUpdate{}, fmt.Errorf("branch block %d '%s' is invalid: %s", blockFs.Value.Header.Number, blockFs.Key.Hex(), err.Error())
+		}
+
+		pendingState.latestBlock = blockFs.Value
+		pendingState.latestBlockHash = blockFs.Key
+		pendingState.hasGenesisBlock = true
+
+		undos = append(undos, newBlockUndo(blockFs.Key, &before, pendingState))
+	}
+
+	update := ChainUpdate{}
+
+	removed := make([]BlockFS, 0)
+	err = s.store.ForEach(forkHeight, func(blockFs BlockFS) error {
+		removed = append(removed, blockFs)
+		return nil
+	})
+	if err != nil {
+		return ChainUpdate{}, err
+	}
+
+	journal := reorgJournal{ForkHeight: forkHeight}
+	for _, blockFs := range branch {
+		journal.Branch = append(journal.Branch, blockFs.Key)
+	}
+
+	if err := writeReorgJournal(s.dataDir, journal); err != nil {
+		return ChainUpdate{}, err
+	}
+
+	for _, blockFs := range removed {
+		if err := s.side.put(blockFs); err != nil {
+			return ChainUpdate{}, err
+		}
+
+		update.Removed = append(update.Removed, blockFs.Value)
+	}
+
+	if err := removeSnapshotsFrom(s.dataDir, forkHeight); err != nil {
+		return ChainUpdate{}, err
+	}
+
+	if err := s.store.TruncateFrom(forkHeight); err != nil {
+		return ChainUpdate{}, err
+	}
+
+	for i, blockFs := range branch {
+		if s.undo != nil {
+			if err := s.undo.put(blockFs.Value.Header.Number, undos[i]); err != nil {
+				return ChainUpdate{}, err
+			}
+		}
+
+		if err := s.store.Append(blockFs); err != nil {
+			return ChainUpdate{}, err
+		}
+
+		if err := s.side.delete(blockFs.Key); err != nil {
+			return ChainUpdate{}, err
+		}
+
+		update.Added = append(update.Added, blockFs.Value)
+	}
+
+	if err := os.Remove(getReorgJournalFilePath(s.dataDir)); err != nil {
+		return ChainUpdate{}, err
+	}
+
+	// the replaced blocks count towards the side blocks kept, the branch blocks left the side store already
+	if err := s.side.prune(MaxSideBlocks, nil); err != nil {
+		fmt.Printf("ERROR: unable to prune the side blocks. %s\n", err.Error())
+	}
+
+	if s.index != nil {
+		err := s.index.unindexBlocks(update.Removed, BlockFS{})
+		for i := 0; err == nil && i < len(branch); i++ {
+			err = s.index.indexBlock(branch[i])
+		}
+		if err != nil {
+			fmt.Printf("ERROR: unable to index the new branch. %s\n", err.Error())
+		}
+	}
+
+	s.Balances = pendingState.Balances
+	s.Account2Nonce = pendingState.Account2Nonce
+	s.minted = pendingState.minted
+	s.supply = pendingState.supply
+	s.recentTimes = pendingState.recentTimes
+	s.work = pendingState.work
+	s.latestBlock = pendingState.latestBlock
+	s.latestBlockHash = pendingState.latestBlockHash
+	s.hasGenesisBlock = true
+
+	fmt.Printf("\nReorganized the chain at height %d: %d blocks replaced by %d blocks, new tip '%s'\n", forkHeight, len(update.Removed), len(update.Added), s.latestBlockHash.Hex())
+
+	return update, nil
+}
+
+// resumeReorg completes the branch switch recorded by the reorg journal, if one was interrupted.
+//
+// Whatever the point the switch stopped at, the branch blocks are either side blocks or already appended,
+// so the switch is simply run again from the fork height.
+func (s *State) resumeReorg() error {
+	journal, ok, err := readReorgJournal(s.dataDir)
+	if err != nil || !ok {
+		return err
+	}
+
+	if len(journal.Branch) == 0 || s.latestBlockHash == journal.Branch[len(journal.Branch)-1] {
+		return os.Remove(getReorgJournalFilePath(s.dataDir))
+	}
+
+	branch := make([]BlockFS, 0, len(journal.Branch))
+	for _, hash := range journal.Branch {
+		blockFs, ok, err := s.side.get(hash)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			if blockFs, err = s.store.BlockByHash(hash); err != nil {
+				return fmt.Errorf("block '%s' of the interrupted reorg is lost", hash.Hex())
+			}
+		}
+
+		branch = append(branch, blockFs)
+	}
+
+	fmt.Printf("Completing the reorg at height %d interrupted before block '%s' became the tip\n", journal.ForkHeight, journal.Branch[len(journal.Branch)-1].Hex())
+
+	_, err = s.switchToBranch(branch)
+
+	return err
+}
@@ -0,0 +1,352 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/jnsoft/gamma/util/security"
+)
+
+func TestImportBlockReorg(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+	caesar := newTestAccount(t)
+
+	genesisBalances := map[Address]Amount{andrej.addr: NewAmount(1000)}
+
+	state, dataDir := newTestState(t, genesisBalances)
+	rival, _ := newTestState(t, genesisBalances)
+
+	block0 := mineTestBlock(t, state, babaYaga.addr, nil)
+	for _, s := range []*State{state, rival} {
+		if _, err := s.AddBlock(block0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tx := andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(100), 1, ""))
+	block1 := mineTestBlock(t, state, babaYaga.addr, []SignedTx{tx})
+	if _, err := state.AddBlock(block1); err != nil {
+		t.Fatal(err)
+	}
+
+	// the rival branch has no tx and pays another miner
+	branch := make([]Block, 0)
+	for i := 0; i < 2; i++ {
+		b := mineTestBlock(t, rival, caesar.addr, nil)
+		if _, err := rival.AddBlock(b); err != nil {
+			t.Fatal(err)
+		}
+		branch = append(branch, b)
+	}
+
+	update, err := state.ImportBlock(branch[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(update.Added) != 0 || update.IsReorg() {
+		t.Fatal("branch with as much work as the main chain should only be stored as a side chain")
+	}
+
+	block1Hash, _ := block1.Hash()
+	if state.LatestBlockHash() != block1Hash {
+		t.Fatal("main chain tip should not change on a tie")
+	}
+
+	update, err = state.ImportBlock(branch[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(update.Removed) != 1 || len(update.Added) != 2 {
+		t.Fatalf("reorg should replace 1 block by 2, replaced %d by %d", len(update.Removed), len(update.Added))
+	}
+
+	if state.LatestBlockHash() != rival.LatestBlockHash() || state.StateRoot() != rival.StateRoot() {
+		t.Fatal("state should have switched to the branch with more work")
+	}
+
+	if state.Balances[andrej.addr] != NewAmount(1000) || state.Account2Nonce[andrej.addr] != 0 {
+		t.Fatal("tx of the replaced block should not be applied anymore")
+	}
+
+	state.Close()
+
+	reloaded, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloaded.Close()
+
+	if reloaded.LatestBlockHash() != rival.LatestBlockHash() || reloaded.StateRoot() != rival.StateRoot() {
+		t.Fatal("reorganized chain should be persisted")
+	}
+
+	if reloaded.TotalWork().Cmp(rival.TotalWork()) != 0 {
+		t.Fatalf("total work should be %s once reloaded, not %s", rival.TotalWork(), reloaded.TotalWork())
+	}
+
+	// the replaced block is kept as a side block, so its branch can still be extended
+	if _, ok, err := reloaded.side.get(block1Hash); err != nil || !ok {
+		t.Fatal("replaced block should be kept as a side block")
+	}
+}
+
+func TestImportBlockMoreWorkFewerBlocks(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+	caesar := newTestAccount(t)
+
+	genesisBalances := map[Address]Amount{andrej.addr: NewAmount(1000)}
+
+	state, _ := newTestState(t, genesisBalances)
+	rival, _ := newTestState(t, genesisBalances)
+
+	block0 := mineTestBlock(t, state, babaYaga.addr, nil)
+	for _, s := range []*State{state, rival} {
+		if _, err := s.AddBlock(block0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if _, err := state.AddBlock(mineTestBlock(t, state, babaYaga.addr, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a single block mined at a higher difficulty took more work than the two it competes with
+	const difficulty = testMiningDifficulty + 1
+	rival.ChangeMiningDifficulty(difficulty)
+	state.ChangeMiningDifficulty(difficulty)
+
+	b := NewBlock(rival.LatestBlockHash(), rival.NextBlockNumber(), 0, 0, caesar.addr, nil)
+	b.Header.StateRoot, _ = rival.NextStateRoot(b)
+	for {
+		b.Header.Nonce = security.GenerateNonce()
+		if hash, _ := b.Hash(); IsBlockHashValid(hash, difficulty) {
+			break
+		}
+	}
+
+	if _, err := rival.AddBlock(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if rival.TotalWork().Cmp(state.TotalWork()) <= 0 {
+		t.Fatalf("branch should have taken more work than the main chain, %s against %s", rival.TotalWork(), state.TotalWork())
+	}
+
+	update, err := state.ImportBlock(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(update.Removed) != 2 || len(update.Added) != 1 {
+		t.Fatalf("reorg should replace 2 blocks by 1, replaced %d by %d", len(update.Removed), len(update.Added))
+	}
+
+	if state.LatestBlockHash() != rival.LatestBlockHash() || state.TotalWork().Cmp(rival.TotalWork()) != 0 {
+		t.Fatal("state should have switched to the branch with more work")
+	}
+}
+
+func TestImportBlockUnknownParent(t *testing.T) {
+	babaYaga := newTestAccount(t)
+
+	state, _ := newTestState(t, map[Address]Amount{})
+	other, _ := newTestState(t, map[Address]Amount{})
+
+	for i := 0; i < 2; i++ {
+		if _, err := other.AddBlock(mineTestBlock(t, other, babaYaga.addr, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := state.ImportBlock(other.LatestBlock()); err == nil {
+		t.Fatal("block with an unknown parent should be rejected")
+	}
+}
+
+// mineTestChild mines an empty block on top of the given one, whether it's valid or not.
+func mineTestChild(t *testing.T, parent Block, miner Address) Block {
+	parentHash, err := parent.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := NewBlock(parentHash, parent.Header.Number+1, 0, parent.Header.Time, miner, nil)
+	for {
+		b.Header.Nonce = security.GenerateNonce()
+
+		hash, err := b.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if IsBlockHashValid(hash, testMiningDifficulty) {
+			return b
+		}
+	}
+}
+
+func TestImportBlockPrunesInvalidBranch(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+	caesar := newTestAccount(t)
+
+	genesisBalances := map[Address]Amount{andrej.addr: NewAmount(1000)}
+
+	state, _ := newTestState(t, genesisBalances)
+	rival, _ := newTestState(t, genesisBalances)
+
+	block0 := mineTestBlock(t, state, babaYaga.addr, nil)
+	for _, s := range []*State{state, rival} {
+		if _, err := s.AddBlock(block0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := state.AddBlock(mineTestBlock(t, state, babaYaga.addr, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	// a valid competing block stays stored, it isn't part of the invalid branch
+	sibling := mineTestBlock(t, rival, caesar.addr, nil)
+	if _, err := state.ImportBlock(sibling); err != nil {
+		t.Fatal(err)
+	}
+
+	// andrej can't send more than his balance, the block and everything mined on it are invalid
+	overspend := andrej.sign(t, NewBaseTx(andrej.addr, caesar.addr, NewAmount(5000), 1, ""))
+	invalid := mineTestBlock(t, rival, caesar.addr, []SignedTx{overspend})
+	child := mineTestChild(t, invalid, caesar.addr)
+
+	if _, err := state.ImportBlock(invalid); err != nil {
+		t.Fatal(err)
+	}
+
+	grandchild := mineTestChild(t, child, caesar.addr)
+	if _, err := state.ImportBlock(child); err == nil {
+		t.Fatal("branch with an invalid block should be rejected")
+	}
+
+	for _, b := range []Block{invalid, child} {
+		hash, _ := b.Hash()
+		if _, ok, _ := state.side.get(hash); ok {
+			t.Fatalf("block %d of the invalid branch should be pruned", b.Header.Number)
+		}
+	}
+
+	siblingHash, _ := sibling.Hash()
+	if _, ok, _ := state.side.get(siblingHash); !ok {
+		t.Fatal("valid side block should be kept")
+	}
+
+	if _, err := state.ImportBlock(grandchild); err == nil {
+		t.Fatal("block descending from a pruned block should have an unknown parent")
+	}
+}
+
+func TestImportBlockResumesInterruptedReorg(t *testing.T) {
+	babaYaga := newTestAccount(t)
+	caesar := newTestAccount(t)
+
+	state, dataDir := newTestState(t, map[Address]Amount{})
+	rival, _ := newTestState(t, map[Address]Amount{})
+
+	block0 := mineTestBlock(t, state, babaYaga.addr, nil)
+	for _, s := range []*State{state, rival} {
+		if _, err := s.AddBlock(block0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := state.AddBlock(mineTestBlock(t, state, babaYaga.addr, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	journal := reorgJournal{ForkHeight: 1}
+	for i := 0; i < 2; i++ {
+		b := mineTestBlock(t, rival, caesar.addr, nil)
+		hash, err := rival.AddBlock(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := state.side.put(BlockFS{hash, b}); err != nil {
+			t.Fatal(err)
+		}
+		journal.Branch = append(journal.Branch, hash)
+	}
+
+	// the node stopped right after truncating the main chain for the branch
+	if err := writeReorgJournal(dataDir, journal); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.store.TruncateFrom(1); err != nil {
+		t.Fatal(err)
+	}
+	state.Close()
+
+	reloaded, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloaded.Close()
+
+	if reloaded.LatestBlockHash() != rival.LatestBlockHash() || reloaded.StateRoot() != rival.StateRoot() {
+		t.Fatal("interrupted reorg should be completed on load")
+	}
+
+	if fileExist(getReorgJournalFilePath(dataDir)) {
+		t.Fatal("reorg journal should be removed once the reorg is complete")
+	}
+}
+
+func TestSideChainPrune(t *testing.T) {
+	side, err := openSideChain(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer side.close()
+
+	// two branches forking off block 0, the lower one 2 blocks long and the higher one starting at block 2
+	put := func(parent Hash, number uint64) BlockFS {
+		b := Block{Header: BlockHeader{Parent: parent, Number: number, Time: uint64(len(side.blocks))}}
+		hash, err := b.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := side.put(BlockFS{hash, b}); err != nil {
+			t.Fatal(err)
+		}
+
+		return BlockFS{hash, b}
+	}
+
+	low := put(Hash{1}, 1)
+	lowChild := put(low.Key, 2)
+	high := put(Hash{2}, 2)
+	highChild := put(high.Key, 3)
+
+	if err := side.prune(3, []BlockFS{high, highChild}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(side.blocks) != 2 {
+		t.Fatalf("lowest block and its child should be pruned, %d side blocks left", len(side.blocks))
+	}
+
+	for _, blockFs := range []BlockFS{low, lowChild} {
+		if _, ok, _ := side.get(blockFs.Key); ok {
+			t.Fatalf("side block %d should be pruned", blockFs.Value.Header.Number)
+		}
+	}
+
+	// only kept blocks are left, the limit can't be reached
+	if err := side.prune(0, []BlockFS{high, highChild}); err != nil || len(side.blocks) != 2 {
+		t.Fatal("kept blocks should never be pruned")
+	}
+}
@@ -0,0 +1,263 @@
+package database
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SnapshotInterval is the number of blocks between two automatic state snapshots.
+const SnapshotInterval = 1000
+
+// Snapshot is the state (balances and nonces) right after the block with the given height and hash was applied.
+type Snapshot struct {
+	Height   uint64             `json:"height"`
+	Hash     Hash               `json:"hash"`
+	Balances map[Address]Amount `json:"balances"`
+	Nonces   map[Address]uint   `json:"nonces"`
+	Minted   *MintLedger        `json:"minted,omitempty"` // only set once tokens were minted
+	Supply   *Supply            `json:"supply,omitempty"` // missing from snapshots taken before the supply was tracked
+	Work     *big.Int           `json:"work,omitempty"`   // missing from snapshots taken before the chain work was tracked
+	Checksum Hash               `json:"checksum"`
+}
+
+// SnapshotFile describes a snapshot stored in the data dir without loading its content.
+type SnapshotFile struct {
+	Height uint64
+	Hash   Hash
+	Path   string
+}
+
+func (s Snapshot) checksum() (Hash, error) {
+	s.Checksum = Hash{}
+
+	snapshotJson, err := json.Marshal(s)
+	if err != nil {
+		return Hash{}, err
+	}
+
+	return sha256.Sum256(snapshotJson), nil
+}
+
+// CreateSnapshot writes the current state of the chain tip to the data dir.
+func (s *State) CreateSnapshot() (SnapshotFile, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.createSnapshot()
+}
+
+func (s *State) createSnapshot() (SnapshotFile, error) {
+	if !s.hasGenesisBlock {
+		return SnapshotFile{}, fmt.Errorf("there are no blocks to snapshot yet")
+	}
+
+	supply := s.supply
+	snapshot := Snapshot{
+		Height:   s.latestBlock.Header.Number,
+		Hash:     s.latestBlockHash,
+		Balances: s.Balances,
+		Nonces:   s.Account2Nonce,
+		Supply:   &supply,
+		Work:     s.work,
+	}
+
+	if s.minted != (MintLedger{}) {
+		minted := s.minted
+		snapshot.Minted = &minted
+	}
+
+	return writeSnapshot(s.dataDir, snapshot)
+}
+
+func writeSnapshot(dataDir string, snapshot Snapshot) (SnapshotFile, error) {
+	checksum, err := snapshot.checksum()
+	if err != nil {
+		return SnapshotFile{}, err
+	}
+	snapshot.Checksum = checksum
+
+	snapshotJson, err := json.Marshal(snapshot)
+	if err != nil {
+		return SnapshotFile{}, err
+	}
+
+	if err := os.MkdirAll(getSnapshotsDirPath(dataDir), os.ModePerm); err != nil {
+		return SnapshotFile{}, err
+	}
+
+	file := SnapshotFile{snapshot.Height, snapshot.Hash, getSnapshotFilePath(dataDir, snapshot.Height, snapshot.Hash)}
+
+	tmpPath := file.Path + ".tmp"
+	if err := os.WriteFile(tmpPath, snapshotJson, 0600); err != nil {
+		return SnapshotFile{}, err
+	}
+
+	return file, os.Rename(tmpPath, file.Path)
+}
+
+// LoadSnapshot reads the snapshot file and verifies its checksum.
+func LoadSnapshot(file SnapshotFile) (Snapshot, error) {
+	content, err := os.ReadFile(file.Path)
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return Snapshot{}, err
+	}
+
+	checksum, err := snapshot.checksum()
+	if err != nil {
+		return Snapshot{}, err
+	}
+
+	if checksum != snapshot.Checksum || snapshot.Height != file.Height || snapshot.Hash != file.Hash {
+		return Snapshot{}, fmt.Errorf("snapshot '%s' is corrupted", file.Path)
+	}
+
+	if snapshot.Balances == nil {
+		snapshot.Balances = make(map[Address]Amount)
+	}
+
+	if snapshot.Nonces == nil {
+		snapshot.Nonces = make(map[Address]uint)
+	}
+
+	return snapshot, nil
+}
+
+// ListSnapshots returns the snapshots of the data dir ordered by height, newest last.
+func ListSnapshots(dataDir string) ([]SnapshotFile, error) {
+	dirEntries, err := os.ReadDir(getSnapshotsDirPath(dataDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	files := make([]SnapshotFile, 0, len(dirEntries))
+	for _, entry := range dirEntries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+
+		heightRaw, hashRaw, ok := strings.Cut(name, "-")
+		if !ok {
+			continue
+		}
+
+		height, err := strconv.ParseUint(heightRaw, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		var hash Hash
+		if len(hashRaw) != 2*HashLength || hash.UnmarshalText([]byte(hashRaw)) != nil {
+			continue
+		}
+
+		files = append(files, SnapshotFile{height, hash, filepath.Join(getSnapshotsDirPath(dataDir), entry.Name())})
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Height < files[j].Height
+	})
+
+	return files, nil
+}
+
+// PruneSnapshots removes all but the newest keep snapshots and returns the removed ones.
+func PruneSnapshots(dataDir string, keep int) ([]SnapshotFile, error) {
+	files, err := ListSnapshots(dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	if keep < 0 {
+		keep = 0
+	}
+
+	if len(files) <= keep {
+		return nil, nil
+	}
+
+	pruned := files[:len(files)-keep]
+	for _, file := range pruned {
+		if err := os.Remove(file.Path); err != nil {
+			return nil, err
+		}
+	}
+
+	return pruned, nil
+}
+
+// latestValidSnapshot returns the newest snapshot, up to the given height, taken of a block which is still part of the stored chain.
+func latestValidSnapshot(dataDir string, store BlockStore, maxHeight uint64) (Snapshot, bool, error) {
+	files, err := ListSnapshots(dataDir)
+	if err != nil {
+		return Snapshot{}, false, err
+	}
+
+	for i := len(files) - 1; i >= 0; i-- {
+		if files[i].Height > maxHeight {
+			continue
+		}
+
+		blockFs, err := store.BlockByHeight(files[i].Height)
+		if err != nil || blockFs.Key != files[i].Hash {
+			fmt.Printf("Skipping snapshot '%s': block %d is not part of the chain\n", files[i].Path, files[i].Height)
+			continue
+		}
+
+		snapshot, err := LoadSnapshot(files[i])
+		if err != nil {
+			fmt.Printf("Skipping snapshot: %s\n", err.Error())
+			continue
+		}
+
+		if snapshot.Supply == nil {
+			fmt.Printf("Skipping snapshot '%s': taken before the supply was tracked\n", files[i].Path)
+			continue
+		}
+
+		if snapshot.Work == nil {
+			fmt.Printf("Skipping snapshot '%s': taken before the chain work was tracked\n", files[i].Path)
+			continue
+		}
+
+		return snapshot, true, nil
+	}
+
+	return Snapshot{}, false, nil
+}
+
+// removeSnapshotsFrom removes the snapshots taken of blocks from the given height on,
+// which are no longer part of the chain once it switched to another branch.
+func removeSnapshotsFrom(dataDir string, height uint64) error {
+	files, err := ListSnapshots(dataDir)
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.Height < height {
+			continue
+		}
+
+		if err := os.Remove(file.Path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
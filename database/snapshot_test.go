@@ -0,0 +1,102 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestNewStateFromDiskStartsFromSnapshot(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+
+	state, dataDir := newTestState(t, map[Address]Amount{andrej.addr: NewAmount(1000)})
+
+	for i := uint(1); i <= 3; i++ {
+		tx := andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(10), i, ""))
+
+		if _, err := state.AddBlock(mineTestBlock(t, state, babaYaga.addr, []SignedTx{tx})); err != nil {
+			t.Fatal(err)
+		}
+
+		if i == 2 {
+			if _, err := state.CreateSnapshot(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	expected := state.Balances[babaYaga.addr]
+	state.Close()
+
+	reloaded, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reloaded.Balances[babaYaga.addr] != expected || reloaded.LatestBlock().Header.Number != 2 {
+		t.Fatalf("state booted from snapshot should match the replayed one, balance %s at height %d", reloaded.Balances[babaYaga.addr], reloaded.LatestBlock().Header.Number)
+	}
+	reloaded.Close()
+
+	// only the blocks after the snapshot are replayed on top of it
+	files, err := ListSnapshots(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) != 1 || files[0].Height != 1 {
+		t.Fatalf("expected a single snapshot at height 1, got %v", files)
+	}
+
+	snapshot, err := LoadSnapshot(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	original := snapshot.Balances[babaYaga.addr]
+	tampered, err := original.Add(NewAmount(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot.Balances[babaYaga.addr] = tampered
+	if _, err := writeSnapshot(dataDir, snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	// replaying block 2 on top of the tampered snapshot no longer matches the state root it commits to
+	if _, err := NewStateFromDisk(dataDir); err == nil {
+		t.Fatal("state should have been booted from the tampered snapshot and rejected by the state root")
+	}
+
+	snapshot.Balances[babaYaga.addr] = original
+	if _, err := writeSnapshot(dataDir, snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err = NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reloaded.Balances[babaYaga.addr] != expected {
+		t.Fatalf("state booted from the snapshot should have %s, not %s", expected, reloaded.Balances[babaYaga.addr])
+	}
+
+	if _, err := reloaded.CreateSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	reloaded.Close()
+
+	pruned, err := PruneSnapshots(dataDir, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err = ListSnapshots(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pruned) != 1 || pruned[0].Height != 1 || len(files) != 1 || files[0].Height != 2 {
+		t.Fatalf("pruning should keep only the newest snapshot, pruned %v, kept %v", pruned, files)
+	}
+}
@@ -8,39 +8,74 @@
 package database
 
 import (
-	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"os"
+	"math"
+	"math/big"
 	"reflect"
 	"sort"
+	"sync"
+	"time"
 )
 
 const TxGas = 21
 const TxGasPriceDefault = 1
 const TxFee = uint(50)
 
+// State is the balances and nonces of the accounts after the latest block of the chain.
+//
+// It is safe for concurrent use: blocks are added, imported and rolled back under an exclusive lock,
+// the other methods share it. The genesis parameters never change and are read without locking.
+// Balances and Account2Nonce may only be read directly on a state no other goroutine uses, such as a Copy;
+// concurrent readers take a View instead.
+//
+// Applying blocks replaces the account maps instead of modifying them, so a view shares the maps it was taken of.
 type State struct {
-	Balances      map[Address]uint
+	Balances      map[Address]Amount
 	Account2Nonce map[Address]uint
 
-	txMempool []SimpleTx // Only for SimpleTx
-
-	dbFile *os.File
+	dataDir string
+	store   BlockStore
+	side    *sideChain
+	undo    *undoJournal
+	index   *chainIndex
 
-	latestBlock     SimpleBlock
+	latestBlock     Block
 	latestBlockHash Hash
 	hasGenesisBlock bool
 
+	// work is the total work of the main chain, replaced rather than modified as blocks are applied
+	work *big.Int
+
 	miningDifficulty uint
 
-	forkTIP1 uint64
+	chainID     string
+	genesisHash Hash
+	blockReward Amount
+
+	forks Forks
+
+	// legacyBlock is the hash of the block 0 carrying a legacy ledger forward, empty if the genesis doesn't commit to one
+	legacyBlock Hash
+
+	mintPolicy *MintPolicy
+	minted     MintLedger
+
+	supply Supply
+
+	// recentTimes are the times of the latest MedianTimeBlocks blocks, oldest first
+	recentTimes  []uint64
+	maxTimeDrift time.Duration
+	clock        func() time.Time
 
-	HashCache   map[string]int64
-	HeightCache map[uint64]int64
+	mu *sync.RWMutex
 }
 
-func NewStateFromDisk(dataDir string, miningDifficulty uint) (*State, error) {
+// NewStateFromDisk loads the state of the data dir, initializing it with the default genesis if it's empty.
+//
+// The consensus parameters, such as the mining difficulty and the block reward, come from the genesis.
+func NewStateFromDisk(dataDir string) (*State, error) {
 	err := InitDataDirIfNotExists(dataDir, []byte(genesisJson))
 	if err != nil {
 		return nil, err
@@ -51,221 +86,435 @@ func NewStateFromDisk(dataDir string, miningDifficulty uint) (*State, error) {
 		return nil, err
 	}
 
-	balances := make(map[Address]uint)
-	for account, balance := range gen.Balances {
-		balances[account] = balance
+	store, err := OpenBlockStore(dataDir)
+	if err != nil {
+		return nil, err
 	}
 
-	account2nonce := make(map[Address]uint)
-
-	dbFilepath := getBlocksDbFilePath(dataDir)
-	f, err := os.OpenFile(dbFilepath, os.O_APPEND|os.O_RDWR, 0600)
+	state, err := loadState(dataDir, gen, store, gen.Difficulty, math.MaxUint64)
 	if err != nil {
+		store.Close()
 		return nil, err
 	}
 
-	scanner := bufio.NewScanner(f)
+	state.side, err = openSideChain(getSideChainDirPath(dataDir))
+	if err != nil {
+		state.Close()
+		return nil, err
+	}
 
-	state := &State{balances, account2nonce, nil, f, SimpleBlock{}, Hash{}, false, miningDifficulty, gen.ForkTIP1, map[string]int64{}, map[uint64]int64{}}
+	state.undo, err = openUndoJournal(getUndoJournalDirPath(dataDir))
+	if err != nil {
+		state.Close()
+		return nil, err
+	}
 
-	// set file position
-	filePos := int64(0)
+	state.index, err = openChainIndex(getChainIndexDirPath(dataDir))
+	if err != nil {
+		state.Close()
+		return nil, err
+	}
 
-	for scanner.Scan() {
-		if err := scanner.Err(); err != nil {
-			return nil, err
-		}
+	err = state.index.catchUp(store)
+	if err != nil {
+		state.Close()
+		return nil, err
+	}
 
-		blockFsJson := scanner.Bytes()
+	err = state.resumeReorg()
+	if err != nil {
+		state.Close()
+		return nil, err
+	}
 
-		if len(blockFsJson) == 0 {
-			break
-		}
+	return state, nil
+}
 
-		var blockFs SimpleBlockFS
-		err = json.Unmarshal(blockFsJson, &blockFs)
-		if err != nil {
-			return nil, err
-		}
+// loadState rebuilds the state right after the stored block at the given height, or after the last stored block
+// if the chain is shorter.
+func loadState(dataDir string, gen Genesis, store BlockStore, miningDifficulty uint, height uint64) (*State, error) {
+	state, from, err := snapshotState(dataDir, gen, store, miningDifficulty, height)
+	if err != nil {
+		return nil, err
+	}
 
-		//err = applyBlock(blockFs.Value, state)
-		err = applySimpleBlock(blockFs.Value, state)
+	if from > height {
+		return state, nil
+	}
 
-		if err != nil {
-			return nil, err
+	err = store.ForEach(from, func(blockFs BlockFS) error {
+		if err := replayBlock(blockFs, state); err != nil {
+			return err
 		}
 
-		// set search caches
-		state.HashCache[blockFs.Key.Hex()] = filePos
-		state.HeightCache[blockFs.Value.Header.Number] = filePos
-		filePos += int64(len(blockFsJson)) + 1
+		if blockFs.Value.Header.Number == height {
+			return errHeightReached
+		}
 
-		state.latestBlock = blockFs.Value
-		state.latestBlockHash = blockFs.Key
-		state.hasGenesisBlock = true
+		return nil
+	})
+	if err != nil && err != errHeightReached {
+		return nil, err
 	}
 
 	return state, nil
 }
 
-/*
-	func (s *State) AddBlocks(blocks []Block) error {
-		for _, b := range blocks {
-			_, err := s.AddBlock(b)
-			if err != nil {
-				return err
-			}
-		}
+// snapshotState returns the state of the newest snapshot at or below the given height still matching the chain,
+// or the genesis state without one, along with the height of the first block to replay on top of it.
+func snapshotState(dataDir string, gen Genesis, store BlockStore, miningDifficulty uint, height uint64) (*State, uint64, error) {
+	state := newGenesisState(dataDir, gen, store, miningDifficulty)
 
-		return nil
+	snapshot, ok, err := latestValidSnapshot(dataDir, store, height)
+	if err != nil || !ok {
+		return state, 0, err
 	}
 
-	func (s *State) AddBlock(b Block) (Hash, error) {
-		pendingState := s.Copy()
+	latestBlock, err := store.BlockByHeight(snapshot.Height)
+	if err != nil {
+		return nil, 0, err
+	}
 
-		err := applyBlock(b, &pendingState)
-		if err != nil {
-			return Hash{}, err
-		}
+	state.Balances = snapshot.Balances
+	state.Account2Nonce = snapshot.Nonces
+	if snapshot.Minted != nil {
+		state.minted = *snapshot.Minted
+	}
+	state.supply = *snapshot.Supply
+	state.work = snapshot.Work
+	state.latestBlock = latestBlock.Value
+	state.latestBlockHash = latestBlock.Key
+	state.hasGenesisBlock = true
 
-		blockHash, err := b.Hash()
-		if err != nil {
-			return Hash{}, err
-		}
+	state.recentTimes, err = loadRecentTimes(store, snapshot.Height)
+	if err != nil {
+		return nil, 0, err
+	}
 
-		blockFs := BlockFS{blockHash, b}
+	return state, snapshot.Height + 1, nil
+}
 
-		blockFsJson, err := json.Marshal(blockFs)
-		if err != nil {
-			return Hash{}, err
-		}
+// replayBlock applies a stored block on top of the state rebuilt so far.
+func replayBlock(blockFs BlockFS, state *State) error {
+	if err := applyBlock(blockFs.Value, state); err != nil {
+		return err
+	}
 
-		fmt.Printf("\nPersisting new Block to disk:\n")
-		fmt.Printf("\t%s\n", blockFsJson)
+	state.latestBlock = blockFs.Value
+	state.latestBlockHash = blockFs.Key
+	state.hasGenesisBlock = true
 
-		// get file pos for cache
-		fs, _ := s.dbFile.Stat()
-		filePos := fs.Size() + 1
+	return nil
+}
 
-		_, err = s.dbFile.Write(append(blockFsJson, '\n'))
-		if err != nil {
-			return Hash{}, err
+var errHeightReached = errors.New("height reached")
+
+// stateReplay is a state to start from, a snapshot or the genesis, and the stored blocks to apply on top of it.
+type stateReplay struct {
+	state  *State
+	blocks []BlockFS
+}
+
+// run applies the blocks and returns the resulting state.
+func (r stateReplay) run() (*State, error) {
+	for _, blockFs := range r.blocks {
+		if err := replayBlock(blockFs, r.state); err != nil {
+			return nil, err
 		}
+	}
 
-		// set search caches
-		s.HashCache[blockFs.Key.Hex()] = filePos
-		s.HeightCache[blockFs.Value.Header.Number] = filePos
+	return r.state, nil
+}
 
-		s.Balances = pendingState.Balances
-		s.Account2Nonce = pendingState.Account2Nonce
-		s.latestBlockHash = blockHash
-		s.latestBlock = b
-		s.hasGenesisBlock = true
-		s.miningDifficulty = pendingState.miningDifficulty
+// stateAt returns the state right after the block at the given height was applied.
+func (s *State) stateAt(height uint64) (*State, error) {
+	replay, err := s.replayAt(height)
+	if err != nil {
+		return nil, err
+	}
+
+	return replay.run()
+}
 
-		return blockHash, nil
+// replayAt reads what the state right after the block at the given height is rebuilt from, without applying
+// the blocks. Only reading it needs the state lock, so readers release the lock before running the replay,
+// which may apply up to SnapshotInterval blocks, rather than hold back new blocks meanwhile.
+func (s *State) replayAt(height uint64) (stateReplay, error) {
+	if !s.hasGenesisBlock || height > s.latestBlock.Header.Number {
+		return stateReplay{}, fmt.Errorf("block %d is not part of the chain", height)
 	}
-*/
-func (s *State) AddSimpleBlock(b SimpleBlock) error {
-	for _, tx := range b.TXs {
-		if err := s.AddSimpleTx(tx); err != nil {
-			return err
+
+	if height == s.latestBlock.Header.Number {
+		c := s.copy()
+		return stateReplay{&c, nil}, nil
+	}
+
+	gen, err := loadGenesis(getGenesisJsonFilePath(s.dataDir))
+	if err != nil {
+		return stateReplay{}, err
+	}
+
+	state, from, err := snapshotState(s.dataDir, gen, s.store, s.miningDifficulty, height)
+	if err != nil {
+		return stateReplay{}, err
+	}
+	state.maxTimeDrift, state.clock = s.maxTimeDrift, s.clock
+
+	blocks := make([]BlockFS, 0)
+	if from <= height {
+		err = s.store.ForEach(from, func(blockFs BlockFS) error {
+			blocks = append(blocks, blockFs)
+
+			if blockFs.Value.Header.Number == height {
+				return errHeightReached
+			}
+
+			return nil
+		})
+		if err != nil && err != errHeightReached {
+			return stateReplay{}, err
 		}
 	}
 
-	return nil
+	return stateReplay{state, blocks}, nil
 }
 
-func (s *State) AddSimpleTx(tx SimpleTx) error {
-	if err := s.applySimple(tx); err != nil {
-		return err
+// genesisState returns the state before the first block was applied.
+func (s *State) genesisState() (*State, error) {
+	gen, err := loadGenesis(getGenesisJsonFilePath(s.dataDir))
+	if err != nil {
+		return nil, err
 	}
 
-	s.txMempool = append(s.txMempool, tx)
+	state := newGenesisState(s.dataDir, gen, s.store, s.miningDifficulty)
+	state.maxTimeDrift, state.clock = s.maxTimeDrift, s.clock
 
-	return nil
+	return state, nil
 }
 
-func (s *State) applySimple(tx SimpleTx) error {
-	if tx.IsMint() {
-		s.Balances[tx.To] += tx.Value
-		return nil
+// newGenesisState returns the state before the first block is applied.
+func newGenesisState(dataDir string, gen Genesis, store BlockStore, miningDifficulty uint) *State {
+	balances := make(map[Address]Amount)
+	for account, balance := range gen.Balances {
+		balances[account] = balance
 	}
 
-	if s.Balances[tx.From] < tx.Value {
-		return fmt.Errorf("insufficient balance")
+	account2nonce := make(map[Address]uint)
+
+	genesisHash, _ := gen.Hash()
+
+	// loadGenesis rejects genesis balances overflowing the supply
+	genesisSupply, _ := gen.Supply()
+
+	legacyBlock := Hash{}
+	if gen.Legacy != nil {
+		legacyBlock = gen.Legacy.Block
 	}
 
-	s.Balances[tx.From] -= tx.Value
-	s.Balances[tx.To] += tx.Value
+	return &State{balances, account2nonce, dataDir, store, nil, nil, nil, Block{}, Hash{}, false, new(big.Int), miningDifficulty, gen.ChainID, genesisHash, gen.BlockReward, gen.Forks, legacyBlock, gen.Mint, MintLedger{}, newGenesisSupply(genesisSupply), nil, DefaultMaxBlockTimeDrift, time.Now, &sync.RWMutex{}}
+}
+
+func (s *State) AddBlocks(blocks []Block) error {
+	for _, b := range blocks {
+		_, err := s.AddBlock(b)
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
 
-func (s *State) Persist() (Hash, error) {
-	block := NewSimpleBlock(s.LatestBlockHash(), s.NextBlockNumber(), ToAddress(A0), s.txMempool)
-	blockHash, err := block.Hash()
+// AddBlock validates the block against a copy of the current state and, only if it is valid
+// and was persisted to the block store, swaps the main state to the pending one.
+func (s *State) AddBlock(b Block) (Hash, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.addBlock(b)
+}
+
+func (s *State) addBlock(b Block) (Hash, error) {
+	pendingState := s.copy()
+
+	err := applyBlock(b, &pendingState)
 	if err != nil {
 		return Hash{}, err
 	}
 
-	blockFs := SimpleBlockFS{blockHash, block}
+	blockHash, err := b.Hash()
+	if err != nil {
+		return Hash{}, err
+	}
+
+	blockFs := BlockFS{blockHash, b}
 
 	blockFsJson, err := json.Marshal(blockFs)
 	if err != nil {
 		return Hash{}, err
 	}
 
-	fmt.Printf("Persisting new Block to disk:\n")
+	fmt.Printf("\nPersisting new Block to disk:\n")
 	fmt.Printf("\t%s\n", blockFsJson)
 
-	if _, err = s.dbFile.Write(append(blockFsJson, '\n')); err != nil {
+	if s.undo != nil {
+		err = s.undo.put(b.Header.Number, newBlockUndo(blockHash, s, &pendingState))
+		if err != nil {
+			return Hash{}, err
+		}
+	}
+
+	err = s.store.Append(blockFs)
+	if err != nil {
 		return Hash{}, err
 	}
+
+	s.Balances = pendingState.Balances
+	s.Account2Nonce = pendingState.Account2Nonce
+	s.minted = pendingState.minted
+	s.supply = pendingState.supply
+	s.recentTimes = pendingState.recentTimes
+	s.work = pendingState.work
 	s.latestBlockHash = blockHash
+	s.latestBlock = b
+	s.hasGenesisBlock = true
+	s.miningDifficulty = pendingState.miningDifficulty
+
+	// the index catches up with the chain on the next start if it can't be updated now
+	if s.index != nil {
+		if err := s.index.indexBlock(blockFs); err != nil {
+			fmt.Printf("ERROR: unable to index block %d. %s\n", b.Header.Number, err.Error())
+		}
+	}
 
-	s.txMempool = []SimpleTx{}
+	if b.Header.Number > 0 && b.Header.Number%SnapshotInterval == 0 {
+		if _, err := s.createSnapshot(); err != nil {
+			fmt.Printf("ERROR: unable to snapshot the state at block %d. %s\n", b.Header.Number, err.Error())
+		}
+	}
 
-	return s.latestBlockHash, nil
+	return blockHash, nil
 }
 
 func (s *State) NextBlockNumber() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.nextBlockNumber()
+}
+
+func (s *State) nextBlockNumber() uint64 {
 	if !s.hasGenesisBlock {
 		return uint64(0)
 	}
 
-	return s.LatestBlock().Header.Number + 1
+	return s.latestBlock.Header.Number + 1
 }
 
-func (s *State) LatestBlock() SimpleBlock {
+func (s *State) LatestBlock() Block {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	return s.latestBlock
 }
 
 func (s *State) LatestBlockHash() Hash {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	return s.latestBlockHash
 }
 
+// TotalWork is the expected number of hashes mining the main chain took, the sum of the work of its blocks.
+func (s *State) TotalWork() *big.Int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return new(big.Int).Set(s.work)
+}
+
 func (s *State) GetNextAccountNonce(account Address) uint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	return s.Account2Nonce[account] + 1
 }
 
+func (s *State) MiningDifficulty() uint {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.miningDifficulty
+}
+
 func (s *State) ChangeMiningDifficulty(newDifficulty uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	s.miningDifficulty = newDifficulty
 }
 
-func (s *State) IsTIP1Fork() bool {
-	return s.NextBlockNumber() >= s.forkTIP1
+// ChainID is the chain identifier from the genesis.
+func (s *State) ChainID() string {
+	return s.chainID
 }
 
+// GenesisHash identifies the chain, nodes only sync with peers sharing it.
+func (s *State) GenesisHash() Hash {
+	return s.genesisHash
+}
+
+// BlockReward is paid to the miner of every block on top of the fees.
+func (s *State) BlockReward() Amount {
+	return s.blockReward
+}
+
+// Rules are the consensus rules of the next block.
+func (s *State) Rules() Rules {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.rules()
+}
+
+func (s *State) rules() Rules {
+	return s.RulesAt(s.nextBlockNumber())
+}
+
+// RulesAt returns the consensus rules of the block at the given height.
+func (s *State) RulesAt(height uint64) Rules {
+	return NewRules(s.forks, s.blockReward, height)
+}
+
+// Copy returns a copy of the state, without access to the stored chain, to apply transactions to.
 func (s *State) Copy() State {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.copy()
+}
+
+func (s *State) copy() State {
 	c := State{}
+	c.mu = &sync.RWMutex{}
 	c.hasGenesisBlock = s.hasGenesisBlock
 	c.latestBlock = s.latestBlock
 	c.latestBlockHash = s.latestBlockHash
-	c.Balances = make(map[Address]uint)
+	c.work = s.work
+	c.Balances = make(map[Address]Amount)
 	c.Account2Nonce = make(map[Address]uint)
 	c.miningDifficulty = s.miningDifficulty
-	c.forkTIP1 = s.forkTIP1
+	c.chainID = s.chainID
+	c.genesisHash = s.genesisHash
+	c.blockReward = s.blockReward
+	c.forks = s.forks
+	c.legacyBlock = s.legacyBlock
+	c.mintPolicy = s.mintPolicy
+	c.minted = s.minted
+	c.supply = s.supply
+	c.recentTimes = s.recentTimes
+	c.maxTimeDrift = s.maxTimeDrift
+	c.clock = s.clock
 
 	for acc, balance := range s.Balances {
 		c.Balances[acc] = balance
@@ -279,21 +528,51 @@ func (s *State) Copy() State {
 }
 
 func (s *State) Close() error {
-	return s.dbFile.Close()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.store.Close()
+
+	if s.side != nil {
+		if sideErr := s.side.close(); err == nil {
+			err = sideErr
+		}
+	}
+
+	if s.undo != nil {
+		if undoErr := s.undo.close(); err == nil {
+			err = undoErr
+		}
+	}
+
+	if s.index != nil {
+		if indexErr := s.index.close(); err == nil {
+			err = indexErr
+		}
+	}
+
+	return err
 }
 
 // applyBlock verifies if block can be added to the blockchain.
 //
 // Block metadata are verified as well as transactions within (sufficient balances, etc).
 func applyBlock(b Block, s *State) error {
-	nextExpectedBlockNumber := s.latestBlock.Header.Number + 1
+	// the same linkage db verify checks: the first block has height 0 and no parent, every later one follows the tip
+	if !s.hasGenesisBlock {
+		if b.Header.Number != 0 || !b.Header.Parent.IsEmpty() {
+			return fmt.Errorf("the first block must have height 0 and no parent")
+		}
+	} else {
+		nextExpectedBlockNumber := s.latestBlock.Header.Number + 1
 
-	if s.hasGenesisBlock && b.Header.Number != nextExpectedBlockNumber {
-		return fmt.Errorf("next expected block must be '%d' not '%d'", nextExpectedBlockNumber, b.Header.Number)
-	}
+		if b.Header.Number != nextExpectedBlockNumber {
+			return fmt.Errorf("next expected block must be '%d' not '%d'", nextExpectedBlockNumber, b.Header.Number)
+		}
 
-	if s.hasGenesisBlock && s.latestBlock.Header.Number > 0 && !reflect.DeepEqual(b.Header.Parent, s.latestBlockHash) {
-		return fmt.Errorf("next block parent hash must be '%x' not '%x'", s.latestBlockHash, b.Header.Parent)
+		if !reflect.DeepEqual(b.Header.Parent, s.latestBlockHash) {
+			return fmt.Errorf("next block parent hash must be '%x' not '%x'", s.latestBlockHash, b.Header.Parent)
+		}
 	}
 
 	hash, err := b.Hash()
@@ -301,80 +580,127 @@ func applyBlock(b Block, s *State) error {
 		return err
 	}
 
+	// the block carrying a legacy ledger forward is committed to by the genesis rather than mined
+	isLegacy, err := s.checkLegacyBlock(b, hash)
+	if err != nil {
+		return err
+	}
+
+	if isLegacy {
+		if err := applyLegacyBlock(b, s); err != nil {
+			return err
+		}
+
+		s.recentTimes = withBlockTime(s.recentTimes, b.Header.Time)
+		s.work = new(big.Int).Add(s.work, blockWork(hash))
+
+		return nil
+	}
+
 	if !IsBlockHashValid(hash, s.miningDifficulty) {
 		return fmt.Errorf("invalid block hash %x", hash)
 	}
 
-	err = applyTXs(b.TXs, s)
-	if err != nil {
+	if err := s.checkBlockTime(b); err != nil {
 		return err
 	}
 
-	s.Balances[b.Header.Miner] += BlockReward
-	if s.IsTIP1Fork() {
-		s.Balances[b.Header.Miner] += b.GasReward()
-	} else {
-		s.Balances[b.Header.Miner] += uint(len(b.TXs)) * TxFee
+	if err := s.checkBlockEncoding(b); err != nil {
+		return err
+	}
+
+	if err := applyBlockBody(b, s); err != nil {
+		return err
 	}
 
+	s.recentTimes = withBlockTime(s.recentTimes, b.Header.Time)
+	s.work = new(big.Int).Add(s.work, blockWork(hash))
+
 	return nil
 }
 
-func applySimpleBlock(b SimpleBlock, s *State) error {
-	nextExpectedBlockNumber := s.NextBlockNumber()
-
-	if s.hasGenesisBlock && b.Header.Number != nextExpectedBlockNumber {
-		return fmt.Errorf("next expected block must be '%d' not '%d'", nextExpectedBlockNumber, b.Header.Number)
+// applyBlockBody checks the payload matches the tx root, applies the block transactions, pays the miner
+// and checks the resulting state matches the state root, without checking the block metadata.
+//
+// It also asserts the block only creates the tokens of the block reward and its mint transactions.
+func applyBlockBody(b Block, s *State) error {
+	// a binary header is hashed without the payload, only its tx root ties the payload to the block hash
+	if b.Header.Version != EncodingLegacyJSON && b.Header.TxRoot.IsEmpty() {
+		return fmt.Errorf("block of encoding version %d must commit to its payload with a tx root", b.Header.Version)
 	}
 
-	if s.hasGenesisBlock && s.latestBlock.Header.Number > 0 && !reflect.DeepEqual(b.Header.Parent, s.latestBlockHash) {
-		return fmt.Errorf("next block parent hash must be '%x' not '%x'", s.latestBlockHash, b.Header.Parent)
+	if !b.Header.TxRoot.IsEmpty() {
+		txRoot, err := TxMerkleRoot(b.TXs)
+		if err != nil {
+			return err
+		}
+
+		if txRoot != b.Header.TxRoot {
+			return fmt.Errorf("block tx root must be '%s' not '%s'", txRoot.Hex(), b.Header.TxRoot.Hex())
+		}
 	}
 
-	hash, err := b.Hash()
+	touched := b.touchedAccounts()
+	balancesBefore := sumBalances(s.Balances, touched)
+	mintedBefore := s.minted.Total
+
+	err := applyTXs(b.TXs, s)
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("Hash: %x", hash)
+	rules := s.rules()
 
-	err = applySimpleTXs(b.TXs, s)
+	reward, err := b.MinerReward(rules)
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid miner reward: %s", err.Error())
 	}
 
-	s.Balances[b.Header.Miner] += BlockReward
-	if s.IsTIP1Fork() {
-		s.Balances[b.Header.Miner] += b.GasReward()
-	} else {
-		s.Balances[b.Header.Miner] += uint(len(b.TXs)) * TxFee
+	minerBalance, err := s.Balances[b.Header.Miner].Add(reward)
+	if err != nil {
+		return fmt.Errorf("miner '%s' balance overflows: %s", b.Header.Miner.String(), err.Error())
 	}
+	s.Balances[b.Header.Miner] = minerBalance
 
-	return nil
-}
+	// the miner reward is the block reward plus the fees, and the mint ledger only grows
+	fees, _ := reward.Sub(rules.BlockReward)
+	minted, _ := s.minted.Total.Sub(mintedBefore)
 
-func applyTXs(txs []SignedTx, s *State) error {
-	sort.Slice(txs, func(i, j int) bool {
-		return txs[i].Time < txs[j].Time
-	})
+	allowed, err := rules.BlockReward.Add(minted)
+	if err != nil {
+		return fmt.Errorf("supply overflows: %s", err.Error())
+	}
 
-	for _, tx := range txs {
-		err := ApplyTx(tx, s)
-		if err != nil {
-			return err
+	created := new(big.Int).Sub(sumBalances(s.Balances, touched), balancesBefore)
+	if created.Cmp(allowed.ToBig()) != 0 {
+		return fmt.Errorf("block creates %s TGL instead of the %s TGL of the block reward and mint transactions", created.String(), allowed)
+	}
+
+	s.supply, err = s.supply.withBlock(rules.BlockReward, fees, minted)
+	if err != nil {
+		return err
+	}
+
+	if !b.Header.StateRoot.IsEmpty() {
+		stateRoot := s.stateRoot()
+		if stateRoot != b.Header.StateRoot {
+			return fmt.Errorf("block state root must be '%s' not '%s'", stateRoot.Hex(), b.Header.StateRoot.Hex())
 		}
 	}
 
 	return nil
 }
 
-func applySimpleTXs(txs []SimpleTx, s *State) error {
+// applyTXs applies the transactions ordered by time, without reordering the block payload itself
+// which the block hash and tx root commit to.
+func applyTXs(txs []SignedTx, s *State) error {
+	txs = append([]SignedTx{}, txs...)
 	sort.Slice(txs, func(i, j int) bool {
 		return txs[i].Time < txs[j].Time
 	})
 
 	for _, tx := range txs {
-		err := ApplySimpleTx(tx, s)
+		err := applyTx(tx, s)
 		if err != nil {
 			return err
 		}
@@ -383,28 +709,53 @@ func applySimpleTXs(txs []SimpleTx, s *State) error {
 	return nil
 }
 
+// ApplyTx validates the tx and applies it to the state in place. It's meant for a Copy, such as the pending state
+// of a node, since the maps of a state which views are taken of must not be modified.
 func ApplyTx(tx SignedTx, s *State) error {
-	err := ValidateTx(tx, s)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return applyTx(tx, s)
+}
+
+func applyTx(tx SignedTx, s *State) error {
+	err := validateTx(tx, s)
 	if err != nil {
 		return err
 	}
 
-	s.Balances[tx.From] -= tx.Cost(s.IsTIP1Fork())
-	s.Balances[tx.To] += tx.Value
+	debit, err := s.txDebit(tx.Tx, s.rules())
+	if err != nil {
+		return err
+	}
 
-	s.Account2Nonce[tx.From] = tx.Nonce
+	fromBalance, err := s.Balances[tx.From].Sub(debit)
+	if err != nil {
+		return err
+	}
 
-	return nil
-}
+	minted := s.minted
+	if s.isMintTx(tx.Tx) {
+		minted, err = s.mintedWith(tx.Tx)
+		if err != nil {
+			return err
+		}
+	}
 
-func ApplySimpleTx(tx SimpleTx, s *State) error {
-	err := ValidateSimpleTx(tx, s)
+	// the sender may also be the recipient, who is credited after the cost is debited
+	toBalance := s.Balances[tx.To]
+	if tx.To == tx.From {
+		toBalance = fromBalance
+	}
+
+	toBalance, err = toBalance.Add(tx.Value)
 	if err != nil {
-		return err
+		return fmt.Errorf("wrong TX. Recipient '%s' balance overflows: %s", tx.To.String(), err.Error())
 	}
 
-	s.Balances[tx.From] -= tx.Cost(s.IsTIP1Fork())
-	s.Balances[tx.To] += tx.Value
+	s.Balances[tx.From] = fromBalance
+	s.Balances[tx.To] = toBalance
+	s.minted = minted
 
 	s.Account2Nonce[tx.From] = tx.Nonce
 
@@ -412,6 +763,13 @@ func ApplySimpleTx(tx SimpleTx, s *State) error {
 }
 
 func ValidateTx(tx SignedTx, s *State) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return validateTx(tx, s)
+}
+
+func validateTx(tx SignedTx, s *State) error {
 	ok, err := tx.IsAuthentic()
 	if err != nil {
 		return err
@@ -421,18 +779,38 @@ func ValidateTx(tx SignedTx, s *State) error {
 		return fmt.Errorf("wrong TX. Sender '%s' is forged", tx.From.String())
 	}
 
-	expectedNonce := s.GetNextAccountNonce(tx.From)
+	expectedNonce := s.Account2Nonce[tx.From] + 1
 	if tx.Nonce != expectedNonce {
 		return fmt.Errorf("wrong TX. Sender '%s' next nonce must be '%d', not '%d'", tx.From.String(), expectedNonce, tx.Nonce)
 	}
 
-	if s.IsTIP1Fork() {
+	rules := s.rules()
+
+	// a tx signed for a chain is never valid on another one, from TIP2 on every tx must be signed for a chain
+	if tx.ChainID != "" {
+		if tx.ChainID != s.chainID {
+			return fmt.Errorf("wrong TX. Signed for chain '%s', not '%s'", tx.ChainID, s.chainID)
+		}
+
+		// the legacy JSON encoding doesn't cover the chain ID
+		if tx.Version == EncodingLegacyJSON {
+			return fmt.Errorf("wrong TX. The chain ID isn't signed with encoding version %d", tx.Version)
+		}
+	} else if rules.IsTIP2 {
+		return fmt.Errorf("wrong TX. Must be signed for chain '%s' since TIP2 fork is active", s.chainID)
+	}
+
+	if rules.IsTIP4 && tx.Version == EncodingLegacyJSON {
+		return fmt.Errorf("wrong TX. Must use encoding version %d since TIP4 fork is active", EncodingRLPv1)
+	}
+
+	if rules.IsTIP1 {
 		// For now we only have one type, transfer TXs, so all TXs must pay 21 gas like on Ethereum (21 000)
 		if tx.Gas != TxGas {
 			return fmt.Errorf("insufficient TX gas %v. required: %v", tx.Gas, TxGas)
 		}
 
-		if tx.GasPrice < TxGasPriceDefault {
+		if tx.GasPrice.Cmp(NewAmount(TxGasPriceDefault)) < 0 {
 			return fmt.Errorf("insufficient TX gasPrice %v. required at least: %v", tx.GasPrice, TxGasPriceDefault)
 		}
 
@@ -440,36 +818,34 @@ func ValidateTx(tx SignedTx, s *State) error {
 		// Prior to TIP1, a signed TX must NOT populate the Gas fields to prevent consensus from crashing
 		// It's not enough to add this validation to http_routes.go because a TX could come from another node
 		// that could modify its software and broadcast such a TX, it must be validated here too.
-		if tx.Gas != 0 || tx.GasPrice != 0 {
+		if tx.Gas != 0 || !tx.GasPrice.IsZero() {
 			return fmt.Errorf("invalid TX. `Gas` and `GasPrice` can't be populated before TIP1 fork is active")
 		}
 	}
 
-	if tx.Cost(s.IsTIP1Fork()) > s.Balances[tx.From] {
-		return fmt.Errorf("wrong TX. Sender '%s' balance is %d TBB. Tx cost is %d TBB", tx.From.String(), s.Balances[tx.From], tx.Cost(s.IsTIP1Fork()))
+	if s.isMintTx(tx.Tx) {
+		if _, err := s.mintedWith(tx.Tx); err != nil {
+			return err
+		}
 	}
 
-	return nil
-}
-
-func ValidateSimpleTx(tx SimpleTx, s *State) error {
-	ok, err := tx.IsAuthentic()
+	debit, err := s.txDebit(tx.Tx, rules)
 	if err != nil {
-		return err
+		return fmt.Errorf("wrong TX. Cost overflows: %s", err.Error())
 	}
 
-	if !ok {
-		return fmt.Errorf("wrong TX. Sender '%s' is forged", tx.From.String())
+	if debit.Cmp(s.Balances[tx.From]) > 0 {
+		return fmt.Errorf("wrong TX. Sender '%s' balance is %s TBB. Tx cost is %s TBB", tx.From.String(), s.Balances[tx.From], debit)
 	}
 
-	expectedNonce := s.GetNextAccountNonce(tx.From)
-	if tx.Nonce != expectedNonce {
-		return fmt.Errorf("wrong TX. Sender '%s' next nonce must be '%d', not '%d'", tx.From.String(), expectedNonce, tx.Nonce)
-	}
+	return nil
+}
 
-	if tx.Cost(s.IsTIP1Fork()) > s.Balances[tx.From] {
-		return fmt.Errorf("wrong TX. Sender '%s' balance is %d TBB. Tx cost is %d TBB", tx.From.String(), s.Balances[tx.From], tx.Cost(s.IsTIP1Fork()))
+// txDebit is what the tx takes from the sender balance: its cost, or only the fee for a mint.
+func (s *State) txDebit(tx Tx, rules Rules) (Amount, error) {
+	if s.isMintTx(tx) {
+		return tx.Fee(rules)
 	}
 
-	return nil
+	return tx.Cost(rules)
 }
@@ -0,0 +1,201 @@
+package database
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/jnsoft/gamma/util/security"
+)
+
+const testMiningDifficulty = 1
+
+type testAccount struct {
+	key  *ecdsa.PrivateKey
+	addr Address
+}
+
+func newTestAccount(t *testing.T) testAccount {
+	key, err := ecdsa.GenerateKey(crypto.S256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return testAccount{key, Address(crypto.PubkeyToAddress(key.PublicKey))}
+}
+
+func (a testAccount) sign(t *testing.T, tx Tx) SignedTx {
+	rawTx, err := tx.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txHash := sha256.Sum256(rawTx)
+	sig, err := crypto.Sign(txHash[:], a.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewSignedTx(tx, sig)
+}
+
+func newTestState(t *testing.T, balances map[Address]Amount) (*State, string) {
+	return newTestStateFromGenesis(t, Genesis{ChainID: "gamma-test", Symbol: "TGL", Balances: balances, Difficulty: testMiningDifficulty, BlockReward: NewAmount(BlockReward), ForkTIP1: 0})
+}
+
+func newTestStateFromGenesis(t *testing.T, gen Genesis) (*State, string) {
+	dataDir := t.TempDir()
+
+	genesis, err := json.Marshal(gen)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := InitDataDirIfNotExists(dataDir, genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { state.Close() })
+
+	return state, dataDir
+}
+
+func mineTestBlock(t *testing.T, s *State, miner Address, txs []SignedTx) Block {
+	return mineTestBlockAt(t, s, miner, txs, uint64(len(txs)))
+}
+
+func mineTestBlockAt(t *testing.T, s *State, miner Address, txs []SignedTx, blockTime uint64) Block {
+	b := NewBlock(s.LatestBlockHash(), s.NextBlockNumber(), 0, blockTime, miner, txs)
+
+	// blocks which can't be applied have no state root to commit to
+	if stateRoot, err := s.NextStateRoot(b); err == nil {
+		b.Header.StateRoot = stateRoot
+	}
+
+	return sealTestBlock(t, b)
+}
+
+// sealTestBlock looks for a nonce making the block hash valid, for blocks whose header was changed after mining.
+func sealTestBlock(t *testing.T, b Block) Block {
+	for {
+		b.Header.Nonce = security.GenerateNonce()
+
+		hash, err := b.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if IsBlockHashValid(hash, testMiningDifficulty) {
+			return b
+		}
+	}
+}
+
+func TestAddBlock(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+
+	state, dataDir := newTestState(t, map[Address]Amount{andrej.addr: NewAmount(1000)})
+
+	tx := andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(100), state.GetNextAccountNonce(andrej.addr), ""))
+
+	block0Hash, err := state.AddBlock(mineTestBlock(t, state, babaYaga.addr, []SignedTx{tx}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedAndrej := uint64(1000 - 100 - TxGas*TxGasPriceDefault)
+	expectedBabaYaga := uint64(100 + BlockReward + TxGas*TxGasPriceDefault)
+
+	if state.Balances[andrej.addr] != NewAmount(expectedAndrej) {
+		t.Fatalf("andrej balance should be %d, not %s", expectedAndrej, state.Balances[andrej.addr])
+	}
+
+	if state.Balances[babaYaga.addr] != NewAmount(expectedBabaYaga) {
+		t.Fatalf("baba yaga balance should be %d, not %s", expectedBabaYaga, state.Balances[babaYaga.addr])
+	}
+
+	if state.LatestBlockHash() != block0Hash {
+		t.Fatalf("latest block hash should be %s, not %s", block0Hash.Hex(), state.LatestBlockHash().Hex())
+	}
+
+	block, err := state.GetBlockByHeightOrHash(0, block0Hash.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if block.Key != block0Hash {
+		t.Fatalf("block looked up by hash should be %s, not %s", block0Hash.Hex(), block.Key.Hex())
+	}
+
+	state.Close()
+
+	reloaded, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloaded.Close()
+
+	if reloaded.LatestBlockHash() != block0Hash {
+		t.Fatalf("reloaded latest block hash should be %s, not %s", block0Hash.Hex(), reloaded.LatestBlockHash().Hex())
+	}
+
+	if reloaded.Balances[babaYaga.addr] != NewAmount(expectedBabaYaga) {
+		t.Fatalf("reloaded baba yaga balance should be %d, not %s", expectedBabaYaga, reloaded.Balances[babaYaga.addr])
+	}
+}
+
+func TestAddBlockChecksParent(t *testing.T) {
+	babaYaga := newTestAccount(t)
+
+	state, _ := newTestState(t, map[Address]Amount{})
+
+	if _, err := state.AddBlock(mineTestBlock(t, state, babaYaga.addr, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	// block 1 follows block 0 like every later block follows its parent, as db verify requires
+	orphan := mineTestChild(t, Block{Header: BlockHeader{Time: 1}}, babaYaga.addr)
+	if _, err := state.AddBlock(orphan); err == nil {
+		t.Fatal("block 1 with another parent than block 0 should be rejected")
+	}
+}
+
+func TestAddBlockInvalidLeavesStateUntouched(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+
+	state, _ := newTestState(t, map[Address]Amount{andrej.addr: NewAmount(1000)})
+
+	valid := andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(100), 1, ""))
+	overspend := andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(10000), 2, ""))
+
+	_, err := state.AddBlock(mineTestBlock(t, state, babaYaga.addr, []SignedTx{valid, overspend}))
+	if err == nil {
+		t.Fatal("block spending more than the sender balance should be rejected")
+	}
+
+	if state.Balances[andrej.addr] != NewAmount(1000) {
+		t.Fatalf("rejected block must not change balances, andrej has %s", state.Balances[andrej.addr])
+	}
+
+	if state.Account2Nonce[andrej.addr] != 0 {
+		t.Fatalf("rejected block must not change nonces, andrej nonce is %d", state.Account2Nonce[andrej.addr])
+	}
+
+	blocks, err := state.GetBlocksAfter(Hash{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !state.LatestBlockHash().IsEmpty() || len(blocks) != 0 {
+		t.Fatal("rejected block must not be persisted")
+	}
+}
@@ -0,0 +1,162 @@
+package database
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// AccountProof proves the balance and nonce of an account in the state right after the block with the given header.
+type AccountProof struct {
+	Account   Address      `json:"account"`
+	Balance   Amount       `json:"balance"`
+	Nonce     uint         `json:"nonce"`
+	BlockHash Hash         `json:"block_hash"`
+	Header    BlockHeader  `json:"block_header"`
+	Proof     []MerkleNode `json:"proof"`
+}
+
+// Verify checks the proof against the state root of the header.
+func (p AccountProof) Verify() bool {
+	return VerifyMerkleProof(p.Header.StateRoot, accountLeaf(p.Account, p.Balance, p.Nonce), p.Proof)
+}
+
+// accountLeaf commits to the address, balance and nonce of an account.
+//
+// A balance fitting 64 bits takes 8 bytes, as before balances were 256-bit, so existing state roots are unchanged.
+// A larger one takes 32 bytes, the length of the leaf telling both apart.
+func accountLeaf(account Address, balance Amount, nonce uint) Hash {
+	leaf := append([]byte{}, account[:]...)
+	if balance.IsUint64() {
+		leaf = binary.BigEndian.AppendUint64(leaf, balance.Uint64())
+	} else {
+		balance32 := balance.Bytes32()
+		leaf = append(leaf, balance32[:]...)
+	}
+	leaf = binary.BigEndian.AppendUint64(leaf, uint64(nonce))
+
+	return sha256.Sum256(leaf)
+}
+
+// stateAccounts returns the accounts with a balance or a nonce, ordered by address.
+//
+// Accounts with neither are left out so the root doesn't depend on zero entries a node happens to keep.
+func (s *State) stateAccounts() []Address {
+	seen := make(map[Address]bool)
+	accounts := make([]Address, 0, len(s.Balances))
+
+	add := func(account Address) {
+		if seen[account] || (s.Balances[account].IsZero() && s.Account2Nonce[account] == 0) {
+			return
+		}
+
+		seen[account] = true
+		accounts = append(accounts, account)
+	}
+
+	for account := range s.Balances {
+		add(account)
+	}
+
+	for account := range s.Account2Nonce {
+		add(account)
+	}
+
+	sort.Slice(accounts, func(i, j int) bool {
+		return bytes.Compare(accounts[i][:], accounts[j][:]) < 0
+	})
+
+	return accounts
+}
+
+func (s *State) stateLeaves(accounts []Address) []Hash {
+	leaves := make([]Hash, len(accounts))
+	for i, account := range accounts {
+		leaves[i] = accountLeaf(account, s.Balances[account], s.Account2Nonce[account])
+	}
+
+	return leaves
+}
+
+// StateRoot returns the Merkle root over every account balance and nonce, ordered by address.
+func (s *State) StateRoot() Hash {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.stateRoot()
+}
+
+func (s *State) stateRoot() Hash {
+	return MerkleRoot(s.stateLeaves(s.stateAccounts()))
+}
+
+// NextStateRoot returns the state root the block would result in if it was applied on top of the current state.
+//
+// Used by miners to commit to the state root before sealing the block.
+func (s *State) NextStateRoot(b Block) (Hash, error) {
+	s.mu.RLock()
+	pendingState := s.copy()
+	s.mu.RUnlock()
+
+	b.Header.StateRoot = Hash{}
+	if err := applyBlockBody(b, &pendingState); err != nil {
+		return Hash{}, err
+	}
+
+	return pendingState.stateRoot(), nil
+}
+
+// GetAccountProof returns the Merkle proof of the account balance and nonce right after the block at the given height.
+func (s *State) GetAccountProof(account Address, height uint64) (AccountProof, error) {
+	s.mu.RLock()
+	blockFs, replay, err := s.accountProofReplay(height)
+	s.mu.RUnlock()
+	if err != nil {
+		return AccountProof{}, err
+	}
+
+	// the state is replayed without the lock, so new blocks aren't held back meanwhile
+	state, err := replay.run()
+	if err != nil {
+		return AccountProof{}, err
+	}
+
+	accounts := state.stateAccounts()
+	index := sort.Search(len(accounts), func(i int) bool {
+		return bytes.Compare(accounts[i][:], account[:]) >= 0
+	})
+	if index == len(accounts) || accounts[index] != account {
+		return AccountProof{}, fmt.Errorf("account '%s' has neither a balance nor a nonce at block %d", account.String(), height)
+	}
+
+	proof, err := MerkleProof(state.stateLeaves(accounts), index)
+	if err != nil {
+		return AccountProof{}, err
+	}
+
+	return AccountProof{
+		Account:   account,
+		Balance:   state.Balances[account],
+		Nonce:     state.Account2Nonce[account],
+		BlockHash: blockFs.Key,
+		Header:    blockFs.Value.Header,
+		Proof:     proof,
+	}, nil
+}
+
+func (s *State) accountProofReplay(height uint64) (BlockFS, stateReplay, error) {
+	blockFs, err := s.store.BlockByHeight(height)
+	if err != nil {
+		return BlockFS{}, stateReplay{}, err
+	}
+
+	if blockFs.Value.Header.StateRoot.IsEmpty() {
+		return BlockFS{}, stateReplay{}, fmt.Errorf("block %d was mined before blocks committed to a state root", height)
+	}
+
+	replay, err := s.replayAt(height)
+
+	return blockFs, replay, err
+}
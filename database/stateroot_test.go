@@ -0,0 +1,84 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestAccountProof(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+
+	state, _ := newTestState(t, map[Address]Amount{andrej.addr: NewAmount(1000)})
+
+	for i := uint(1); i <= 3; i++ {
+		tx := andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(100), i, ""))
+		if _, err := state.AddBlock(mineTestBlock(t, state, babaYaga.addr, []SignedTx{tx})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if state.StateRoot() != state.LatestBlock().Header.StateRoot {
+		t.Fatal("state root should match the root committed by the latest block")
+	}
+
+	for height := uint64(0); height < 3; height++ {
+		proof, err := state.GetAccountProof(andrej.addr, height)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected := NewAmount(1000 - (height+1)*(100+TxGas*TxGasPriceDefault))
+		if proof.Balance != expected || proof.Nonce != uint(height+1) {
+			t.Fatalf("andrej should have %s TGL and nonce %d at block %d, not %s TGL and nonce %d", expected, height+1, height, proof.Balance, proof.Nonce)
+		}
+
+		if !proof.Verify() {
+			t.Fatalf("proof at block %d should be valid", height)
+		}
+
+		proof.Balance, err = proof.Balance.Add(NewAmount(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if proof.Verify() {
+			t.Fatalf("proof at block %d should not be valid for another balance", height)
+		}
+	}
+
+	if _, err := state.GetAccountProof(newTestAccount(t).addr, 2); err == nil {
+		t.Fatal("unknown account should have no proof")
+	}
+}
+
+func TestAddBlockWrongStateRoot(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+
+	state, _ := newTestState(t, map[Address]Amount{andrej.addr: NewAmount(1000)})
+
+	tx := andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(100), 1, ""))
+	block := mineTestBlock(t, state, babaYaga.addr, []SignedTx{tx})
+
+	// a root of a state in which the miner wasn't paid
+	c := state.Copy()
+	if err := applyTXs(block.TXs, &c); err != nil {
+		t.Fatal(err)
+	}
+	block.Header.StateRoot = c.StateRoot()
+
+	for {
+		hash, err := block.Hash()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if IsBlockHashValid(hash, testMiningDifficulty) {
+			break
+		}
+		block.Header.Nonce++
+	}
+
+	if _, err := state.AddBlock(block); err == nil {
+		t.Fatal("block with a wrong state root should be rejected")
+	}
+}
@@ -0,0 +1,286 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+const (
+	BlockStoreFile = "file"
+	BlockStoreKV   = "kv"
+)
+
+// errBlockNotFound is wrapped by the errors of block lookups which found no stored block.
+var errBlockNotFound = errors.New("block not found")
+
+// BlockStore persists the canonical chain of blocks.
+//
+// Blocks are always appended in height order, so a store can be replayed from genesis or any later height by ForEach.
+type BlockStore interface {
+	// Append persists the block as the new tip of the chain.
+	Append(b BlockFS) error
+
+	// BlockByHash returns the stored block with the given hash, failing with an error wrapping errBlockNotFound
+	// if there's none.
+	BlockByHash(hash Hash) (BlockFS, error)
+
+	// BlockByHeight returns the stored block with the given height, failing like BlockByHash if there's none.
+	BlockByHeight(height uint64) (BlockFS, error)
+
+	// BlocksAfter returns every block stored after the block with the given hash.
+	// An empty hash returns the whole chain, an unknown hash returns no blocks.
+	BlocksAfter(hash Hash) ([]Block, error)
+
+	// ForEach calls fn for every stored block from the given height on, in height order,
+	// and stops at the first error. It doesn't guard against a concurrent TruncateFrom, the caller holds the state
+	// lock for the whole iteration.
+	ForEach(from uint64, fn func(BlockFS) error) error
+
+	// TruncateFrom removes every stored block from the given height on, so the chain can be switched to another branch.
+	TruncateFrom(height uint64) error
+
+	Close() error
+}
+
+// OpenBlockStore opens the block store of the data dir.
+//
+// The embedded key-value store is used if the data dir was converted to it, otherwise blocks
+// are read from and appended to the block.db file.
+func OpenBlockStore(dataDir string) (BlockStore, error) {
+	if BlockStoreKind(dataDir) == BlockStoreKV {
+		return openKVBlockStore(getBlocksKVDirPath(dataDir))
+	}
+
+	return openFileBlockStore(getBlocksDbFilePath(dataDir), getBlocksIndexFilePath(dataDir))
+}
+
+// BlockStoreKind returns which kind of block store the data dir uses.
+func BlockStoreKind(dataDir string) string {
+	if fileExist(getBlocksKVDirPath(dataDir)) {
+		return BlockStoreKV
+	}
+
+	return BlockStoreFile
+}
+
+// ConvertBlockStore copies every block of the data dir into a new store of the given kind.
+//
+// The new store is built next to its final path with a .tmp suffix and only moved in place once every block
+// was copied, so a failed conversion leaves the data dir on the previous store. That one is kept with a .bak suffix.
+func ConvertBlockStore(dataDir string, to string) error {
+	if err := InitDataDirIfNotExists(dataDir, []byte(genesisJson)); err != nil {
+		return err
+	}
+
+	from := BlockStoreKind(dataDir)
+	if from == to {
+		return fmt.Errorf("data dir already uses the '%s' block store", to)
+	}
+
+	var srcPath, dstPath string
+	switch to {
+	case BlockStoreKV:
+		srcPath, dstPath = getBlocksDbFilePath(dataDir), getBlocksKVDirPath(dataDir)
+	case BlockStoreFile:
+		srcPath, dstPath = getBlocksKVDirPath(dataDir), getBlocksDbFilePath(dataDir)
+	default:
+		return fmt.Errorf("unknown block store '%s', expected '%s' or '%s'", to, BlockStoreFile, BlockStoreKV)
+	}
+
+	tmpPath := dstPath + ".tmp"
+	removeTmp := func() {
+		os.RemoveAll(tmpPath)
+		os.RemoveAll(tmpPath + ".idx")
+	}
+	removeTmp()
+
+	src, err := OpenBlockStore(dataDir)
+	if err != nil {
+		return err
+	}
+
+	var dst BlockStore
+	if to == BlockStoreKV {
+		dst, err = openKVBlockStore(tmpPath)
+	} else {
+		err = writeEmptyBlocksDbToDisk(tmpPath)
+		if err == nil {
+			dst, err = openFileBlockStore(tmpPath, tmpPath+".idx")
+		}
+	}
+	if err != nil {
+		src.Close()
+		removeTmp()
+		return err
+	}
+
+	err = src.ForEach(0, func(blockFs BlockFS) error {
+		return dst.Append(blockFs)
+	})
+	src.Close()
+	if err != nil {
+		dst.Close()
+		removeTmp()
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		removeTmp()
+		return err
+	}
+
+	if err := os.RemoveAll(srcPath + ".bak"); err != nil {
+		return err
+	}
+
+	// the new store is moved in place before the previous one is moved away, the kind only changes once it's complete
+	if to == BlockStoreFile {
+		if err := os.Rename(tmpPath+".idx", getBlocksIndexFilePath(dataDir)); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Rename(tmpPath, dstPath); err != nil {
+		return err
+	}
+
+	if err := os.Rename(srcPath, srcPath+".bak"); err != nil {
+		return err
+	}
+
+	// the index only describes block.db, don't leave it behind once the blocks moved
+	if from == BlockStoreFile {
+		return os.RemoveAll(getBlocksIndexFilePath(dataDir))
+	}
+
+	return nil
+}
+
+// MigrateBlockStoreEncoding rewrites the blocks of the data dir stored as JSON in the binary encoding
+// and returns how many blocks it rewrote. Block hashes are left unchanged.
+//
+// The file store is rewritten into a new block.db, keeping the previous one with a .bak suffix.
+func MigrateBlockStoreEncoding(dataDir string) (int, error) {
+	if err := InitDataDirIfNotExists(dataDir, []byte(genesisJson)); err != nil {
+		return 0, err
+	}
+
+	if BlockStoreKind(dataDir) == BlockStoreKV {
+		return migrateKVBlockStoreEncoding(getBlocksKVDirPath(dataDir))
+	}
+
+	return migrateFileBlockStoreEncoding(getBlocksDbFilePath(dataDir), getBlocksIndexFilePath(dataDir))
+}
+
+func migrateFileBlockStoreEncoding(dbPath, indexPath string) (int, error) {
+	tmpPath := dbPath + ".tmp"
+	removeTmp := func() {
+		os.Remove(tmpPath)
+		os.Remove(tmpPath + ".idx")
+	}
+	removeTmp()
+
+	src, err := openFileBlockStore(dbPath, indexPath)
+	if err != nil {
+		return 0, err
+	}
+
+	var dst *fileBlockStore
+	err = writeEmptyBlocksDbToDisk(tmpPath)
+	if err == nil {
+		dst, err = openFileBlockStore(tmpPath, tmpPath+".idx")
+	}
+	if err != nil {
+		src.Close()
+		removeTmp()
+		return 0, err
+	}
+
+	migrated := 0
+	err = src.ForEach(0, func(blockFs BlockFS) error {
+		migrated++
+		return dst.Append(blockFs)
+	})
+	src.Close()
+	if err != nil {
+		dst.Close()
+		removeTmp()
+		return 0, err
+	}
+
+	if err := dst.Close(); err != nil {
+		removeTmp()
+		return 0, err
+	}
+
+	// the previous block.db is linked to the backup and the new one renamed over it, so block.db always exists.
+	// The index is only a cache, without one it's rebuilt from block.db
+	err = os.RemoveAll(dbPath + ".bak")
+	if err == nil {
+		err = os.Link(dbPath, dbPath+".bak")
+	}
+	if err == nil {
+		err = os.RemoveAll(indexPath)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, dbPath)
+	}
+	if err != nil {
+		removeTmp()
+		return 0, err
+	}
+
+	if err := os.Rename(tmpPath+".idx", indexPath); err != nil {
+		removeTmp()
+		return migrated, err
+	}
+
+	return migrated, nil
+}
+
+func migrateKVBlockStoreEncoding(path string) (int, error) {
+	s, err := openKVBlockStore(path)
+	if err != nil {
+		return 0, err
+	}
+	defer s.Close()
+
+	migrated := 0
+	batch := new(leveldb.Batch)
+
+	it := s.db.NewIterator(util.BytesPrefix(kvBlockPrefix), nil)
+	for it.Next() {
+		if !isJSONRecord(it.Value()) {
+			continue
+		}
+
+		blockFs, err := decodeBlockFS(it.Value())
+		if err != nil {
+			it.Release()
+			return 0, err
+		}
+
+		encoded, err := encodeBlockFS(blockFs)
+		if err != nil {
+			it.Release()
+			return 0, err
+		}
+
+		if !isJSONRecord(encoded) {
+			batch.Put(append([]byte{}, it.Key()...), encoded)
+			migrated++
+		}
+	}
+	it.Release()
+
+	if err := it.Error(); err != nil {
+		return 0, err
+	}
+
+	return migrated, s.db.Write(batch, &opt.WriteOptions{Sync: true})
+}
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestConvertBlockStore(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+
+	state, dataDir := newTestState(t, map[Address]Amount{andrej.addr: NewAmount(1000)})
+
+	var hashes []Hash
+	for i := uint(1); i <= 3; i++ {
+		tx := andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(10), i, ""))
+
+		hash, err := state.AddBlock(mineTestBlock(t, state, babaYaga.addr, []SignedTx{tx}))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		hashes = append(hashes, hash)
+	}
+	state.Close()
+
+	for _, kind := range []string{BlockStoreKV, BlockStoreFile} {
+		if err := ConvertBlockStore(dataDir, kind); err != nil {
+			t.Fatal(err)
+		}
+
+		if BlockStoreKind(dataDir) != kind {
+			t.Fatalf("data dir should use the '%s' block store after conversion", kind)
+		}
+
+		if fileExist(getBlocksKVDirPath(dataDir)+".tmp") || fileExist(getBlocksDbFilePath(dataDir)+".tmp") {
+			t.Fatalf("%s: the temp store should be moved in place after conversion", kind)
+		}
+
+		converted, err := NewStateFromDisk(dataDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if converted.LatestBlockHash() != hashes[2] {
+			t.Fatalf("%s: latest block hash should be %s, not %s", kind, hashes[2].Hex(), converted.LatestBlockHash().Hex())
+		}
+
+		blocks, err := converted.GetBlocksAfter(hashes[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(blocks) != 2 || blocks[0].Header.Number != 1 || blocks[1].Header.Number != 2 {
+			t.Fatalf("%s: expected blocks 1 and 2 after block 0, got %d blocks", kind, len(blocks))
+		}
+
+		block, err := converted.GetBlockByHeightOrHash(1, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if block.Key != hashes[1] {
+			t.Fatalf("%s: block at height 1 should be %s, not %s", kind, hashes[1].Hex(), block.Key.Hex())
+		}
+
+		if _, err := converted.GetBlockByHeightOrHash(3, ""); err == nil {
+			t.Fatalf("%s: looking up a height above the tip should fail", kind)
+		}
+
+		if _, err := converted.store.BlockByHash(Hash{1}); !errors.Is(err, errBlockNotFound) {
+			t.Fatalf("%s: looking up an unknown hash should fail with a not found error, got %v", kind, err)
+		}
+
+		more, err := converted.ForEachBlockAfter(Hash{1}, 10, func(BlockFS) error {
+			return fmt.Errorf("no block follows an unknown hash")
+		})
+		if err != nil || more {
+			t.Fatalf("%s: a page after an unknown hash should be empty, got %v", kind, err)
+		}
+
+		converted.Close()
+	}
+}
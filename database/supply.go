@@ -0,0 +1,95 @@
+package database
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Supply accounts for the tokens of the chain right after a block.
+//
+// Only the genesis balances, the block rewards and the mint transactions create tokens. The fees move from
+// the senders to the miners, so they're tracked without changing the circulating supply.
+type Supply struct {
+	Circulating Amount `json:"circulating"`
+	Genesis     Amount `json:"genesis"`
+	Rewarded    Amount `json:"rewarded"`
+	Minted      Amount `json:"minted"`
+	Fees        Amount `json:"fees"`
+}
+
+// Supply returns the sum of the genesis balances, the initial circulating supply.
+func (g Genesis) Supply() (Amount, error) {
+	var supply Amount
+
+	for account, balance := range g.Balances {
+		var err error
+		supply, err = supply.Add(balance)
+		if err != nil {
+			return Amount{}, fmt.Errorf("genesis balance of '%s' overflows the supply: %s", account.String(), err.Error())
+		}
+	}
+
+	return supply, nil
+}
+
+func newGenesisSupply(genesis Amount) Supply {
+	return Supply{Circulating: genesis, Genesis: genesis}
+}
+
+// withBlock returns the supply once a block paid the reward and fees to its miner and minted tokens.
+func (s Supply) withBlock(reward Amount, fees Amount, minted Amount) (Supply, error) {
+	var err error
+
+	if s.Circulating, err = s.Circulating.Add(reward); err == nil {
+		s.Circulating, err = s.Circulating.Add(minted)
+	}
+	if err == nil {
+		s.Rewarded, err = s.Rewarded.Add(reward)
+	}
+	if err == nil {
+		s.Minted, err = s.Minted.Add(minted)
+	}
+	if err == nil {
+		s.Fees, err = s.Fees.Add(fees)
+	}
+	if err != nil {
+		return Supply{}, fmt.Errorf("supply overflows: %s", err.Error())
+	}
+
+	return s, nil
+}
+
+// Supply returns the supply right after the latest block.
+func (s *State) Supply() Supply {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.supply
+}
+
+// touchedAccounts returns the accounts whose balance the block may change, the miner and the tx parties.
+func (b Block) touchedAccounts() []Address {
+	touched := []Address{b.Header.Miner}
+	for _, tx := range b.TXs {
+		touched = append(touched, tx.From, tx.To)
+	}
+
+	return touched
+}
+
+// sumBalances adds up the balances of the distinct accounts without overflowing.
+func sumBalances(balances map[Address]Amount, accounts []Address) *big.Int {
+	sum := new(big.Int)
+	seen := make(map[Address]bool)
+
+	for _, account := range accounts {
+		if seen[account] {
+			continue
+		}
+		seen[account] = true
+
+		sum.Add(sum, balances[account].ToBig())
+	}
+
+	return sum
+}
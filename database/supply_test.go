@@ -0,0 +1,79 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestSupply(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+	miner := newTestAccount(t)
+
+	state, dataDir := newTestStateFromGenesis(t, Genesis{
+		ChainID:     "gamma-test",
+		Symbol:      "TGL",
+		Balances:    map[Address]Amount{andrej.addr: NewAmount(1000), babaYaga.addr: NewAmount(500)},
+		Difficulty:  testMiningDifficulty,
+		BlockReward: NewAmount(BlockReward),
+		Mint:        &MintPolicy{Authorities: []Address{andrej.addr}},
+	})
+
+	// the circulating supply always adds up to the balances
+	checkSupply := func(expected Supply) {
+		t.Helper()
+
+		var balances Amount
+		for _, balance := range state.Balances {
+			balances, _ = balances.Add(balance)
+		}
+
+		supply := state.Supply()
+		if supply != expected || supply.Circulating != balances {
+			t.Fatalf("expected supply %+v matching balances of %s, got %+v", expected, balances, supply)
+		}
+	}
+
+	checkSupply(Supply{Circulating: NewAmount(1500), Genesis: NewAmount(1500)})
+
+	fee := uint64(TxGas * TxGasPriceDefault)
+	transfer := andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(10), 1, ""))
+	if _, err := state.AddBlock(mineTestBlock(t, state, miner.addr, []SignedTx{transfer})); err != nil {
+		t.Fatal(err)
+	}
+
+	checkSupply(Supply{NewAmount(1500 + BlockReward), NewAmount(1500), NewAmount(BlockReward), Amount{}, NewAmount(fee)})
+
+	mint := andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(300), 2, "mint"))
+	if _, err := state.AddBlock(mineTestBlock(t, state, miner.addr, []SignedTx{mint})); err != nil {
+		t.Fatal(err)
+	}
+
+	afterMint := Supply{NewAmount(1800 + 2*BlockReward), NewAmount(1500), NewAmount(2 * BlockReward), NewAmount(300), NewAmount(2 * fee)}
+	checkSupply(afterMint)
+
+	if _, err := state.CreateSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := state.AddBlock(mineTestBlock(t, state, miner.addr, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := state.RollbackTo(1); err != nil {
+		t.Fatal(err)
+	}
+
+	checkSupply(afterMint)
+	state.Close()
+
+	// the supply is restored from the snapshot
+	reloaded, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloaded.Close()
+
+	if reloaded.Supply() != afterMint {
+		t.Fatalf("reloaded supply should be %+v, got %+v", afterMint, reloaded.Supply())
+	}
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// MedianTimeBlocks is the number of latest blocks whose median time a new block must not precede.
+const MedianTimeBlocks = 11
+
+// DefaultMaxBlockTimeDrift is how far into the future of the node clock a block may be stamped by default.
+const DefaultMaxBlockTimeDrift = 2 * time.Hour
+
+// SetBlockTimePolicy sets how far into the future of the clock a block may be stamped, and the clock itself,
+// time.Now by default. Tests inject a fixed clock.
+func (s *State) SetBlockTimePolicy(maxDrift time.Duration, clock func() time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.maxTimeDrift = maxDrift
+	s.clock = clock
+}
+
+// MedianTime returns the median time of the latest blocks, which the next block must not precede once TIP3 is active.
+// It's zero before the first block.
+func (s *State) MedianTime() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.recentTimes) == 0 {
+		return 0
+	}
+
+	return medianTime(s.recentTimes)
+}
+
+// checkBlockTime enforces, from TIP3 on, that the block isn't stamped before the median time of the latest blocks
+// nor further into the future than the allowed drift.
+func (s *State) checkBlockTime(b Block) error {
+	if !s.rules().IsTIP3 {
+		return nil
+	}
+
+	if len(s.recentTimes) > 0 {
+		median := medianTime(s.recentTimes)
+		if b.Header.Time < median {
+			return fmt.Errorf("block time %d is before %d, the median time of the last %d blocks", b.Header.Time, median, len(s.recentTimes))
+		}
+	}
+
+	latest := s.clock().Add(s.maxTimeDrift)
+	if b.Header.Time > uint64(latest.Unix()) {
+		return fmt.Errorf("block time %d is more than %s ahead of the node clock", b.Header.Time, s.maxTimeDrift)
+	}
+
+	return nil
+}
+
+// withBlockTime returns the times of the latest blocks once the block is applied, without modifying the given slice
+// which copies of the state share.
+func withBlockTime(times []uint64, blockTime uint64) []uint64 {
+	if len(times) == MedianTimeBlocks {
+		times = times[1:]
+	}
+
+	return append(append(make([]uint64, 0, MedianTimeBlocks), times...), blockTime)
+}
+
+// medianTime returns the middle time of the blocks, the later one of the two middle times for an even count.
+func medianTime(times []uint64) uint64 {
+	sorted := append([]uint64{}, times...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	return sorted[len(sorted)/2]
+}
+
+// loadRecentTimes reads the times of the stored blocks up to the given height the median time is taken of.
+func loadRecentTimes(store BlockStore, height uint64) ([]uint64, error) {
+	from := uint64(0)
+	if height+1 > MedianTimeBlocks {
+		from = height + 1 - MedianTimeBlocks
+	}
+
+	times := make([]uint64, 0, MedianTimeBlocks)
+	for h := from; h <= height; h++ {
+		blockFs, err := store.BlockByHeight(h)
+		if err != nil {
+			return nil, err
+		}
+
+		times = append(times, blockFs.Value.Header.Time)
+	}
+
+	return times, nil
+}
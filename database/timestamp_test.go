@@ -0,0 +1,89 @@
+package database
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBlockTimeRules(t *testing.T) {
+	miner := newTestAccount(t)
+
+	state, dataDir := newTestStateFromGenesis(t, Genesis{
+		ChainID:     "gamma-test",
+		Symbol:      "TGL",
+		Balances:    map[Address]Amount{miner.addr: NewAmount(1000)},
+		Difficulty:  testMiningDifficulty,
+		BlockReward: NewAmount(BlockReward),
+		Forks:       Forks{ForkTIP3: 0},
+	})
+
+	now := time.Unix(1_700_000_000, 0)
+	state.SetBlockTimePolicy(time.Hour, func() time.Time { return now })
+
+	addBlockAt := func(blockTime uint64) error {
+		_, err := state.AddBlock(mineTestBlockAt(t, state, miner.addr, nil, blockTime))
+		return err
+	}
+
+	start := uint64(now.Unix()) - 1000
+	for _, offset := range []uint64{0, 100, 100, 300, 200} {
+		if err := addBlockAt(start + offset); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the times so far sort to 0, 100, 100, 200, 300
+	if state.MedianTime() != start+100 {
+		t.Fatalf("median time should be %d, not %d", start+100, state.MedianTime())
+	}
+
+	if err := addBlockAt(start + 99); err == nil {
+		t.Fatal("block before the median time of the latest blocks should be rejected")
+	}
+
+	if err := addBlockAt(start + 100); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := addBlockAt(uint64(now.Add(2 * time.Hour).Unix())); err == nil {
+		t.Fatal("block further in the future than the drift should be rejected")
+	}
+
+	if err := addBlockAt(uint64(now.Add(30 * time.Minute).Unix())); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the latest MedianTimeBlocks blocks count
+	for i := 0; i < MedianTimeBlocks; i++ {
+		if err := addBlockAt(uint64(now.Unix())); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if state.MedianTime() != uint64(now.Unix()) {
+		t.Fatalf("median time should be the time of the last %d blocks, not %d", MedianTimeBlocks, state.MedianTime())
+	}
+
+	// the times of the blocks before the rollback are restored
+	if _, err := state.RollbackTo(4); err != nil {
+		t.Fatal(err)
+	}
+
+	if state.MedianTime() != start+100 {
+		t.Fatalf("median time should be %d after the rollback, not %d", start+100, state.MedianTime())
+	}
+
+	if _, err := VerifyChain(dataDir); err != nil {
+		t.Fatal(err)
+	}
+
+	// without TIP3, block times aren't checked
+	plain, _ := newTestState(t, map[Address]Amount{miner.addr: NewAmount(1000)})
+	plain.SetBlockTimePolicy(time.Hour, func() time.Time { return now })
+
+	for _, blockTime := range []uint64{uint64(now.Add(24 * time.Hour).Unix()), 0} {
+		if _, err := plain.AddBlock(mineTestBlockAt(t, plain, miner.addr, nil, blockTime)); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -7,21 +7,23 @@ import (
 	"time"
 
 	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/jnsoft/gamma/util/misc"
-	"github.com/jnsoft/gamma/util/security"
 )
 
 type Tx struct {
 	From     Address `json:"from"`
 	To       Address `json:"to"`
 	Gas      uint    `json:"gas"`
-	GasPrice uint    `json:"gasPrice"`
-	Value    uint    `json:"value"`
+	GasPrice Amount  `json:"gasPrice"`
+	Value    Amount  `json:"value"`
 	Nonce    uint    `json:"nonce"`
 	Data     string  `json:"data"`
 	Time     uint64  `json:"time"`
+
+	// ChainID is the chain the tx is signed for, so it can't be replayed on another one. Required from TIP2 on.
+	ChainID string `json:"chain_id"`
+
+	Version uint8 `json:"version"` // encoding the tx is signed and hashed over
 }
 
 type SignedTx struct {
@@ -29,69 +31,49 @@ type SignedTx struct {
 	Sig []byte `json:"signature"`
 }
 
-type SimpleTx struct {
-	From  Address `json:"from"`
-	To    Address `json:"to"`
-	Value uint    `json:"value"`
-	Nonce uint    `json:"nonce"`
-	Data  string  `json:"data"`
-	Time  uint64  `json:"time"`
+func NewAccount(value string) Address {
+	return Address(common.HexToAddress(value))
 }
 
-func NewAccount(value string) common.Address {
-	return common.HexToAddress(value)
+func NewTx(from, to Address, gas uint, gasPrice Amount, value Amount, nonce uint, data string) Tx {
+	return Tx{from, to, gas, gasPrice, value, nonce, data, uint64(time.Now().Unix()), "", CurrentEncoding}
 }
 
-func NewSimpleTxStringAddress(from, to string, value uint, data string) SimpleTx {
-	return NewSimpleTx(
-		Address(hexutil.MustDecode(from)),
-		Address(hexutil.MustDecode(to)),
-		value,
-		"")
-}
-
-func NewSimpleTx(from, to Address, value uint, data string) SimpleTx {
-	return SimpleTx{from, to, value, uint(security.GenerateNonce()), data, misc.GetTime()}
-}
-
-func NewTx(from, to Address, gas uint, gasPrice uint, value, nonce uint, data string) Tx {
-	return Tx{from, to, gas, gasPrice, value, nonce, data, uint64(time.Now().Unix())}
-}
-
-func NewBaseTx(from, to Address, value, nonce uint, data string) Tx {
-	return NewTx(from, to, TxGas, TxGasPriceDefault, value, nonce, data)
+func NewBaseTx(from, to Address, value Amount, nonce uint, data string) Tx {
+	return NewTx(from, to, TxGas, NewAmount(TxGasPriceDefault), value, nonce, data)
 }
 
 func NewSignedTx(tx Tx, sig []byte) SignedTx {
 	return SignedTx{tx, sig}
 }
 
+// IsMint tells if the tx is a mint, which it only is on chains whose genesis sets a MintPolicy.
 func (t Tx) IsMint() bool {
 	return t.Data == "mint"
 }
 
-func (t SimpleTx) IsMint() bool {
-	return t.Data == "mint"
-}
-
-func (t Tx) Cost(isTip1Fork bool) uint {
-	if isTip1Fork {
-		return t.Value + t.GasCost()
+// Cost is what the sender pays for the tx under the rules of the block including it.
+// It fails if the value and fee add up to more than an Amount holds.
+func (t Tx) Cost(rules Rules) (Amount, error) {
+	fee, err := t.Fee(rules)
+	if err != nil {
+		return Amount{}, err
 	}
 
-	return t.Value + TxFee
+	return t.Value.Add(fee)
 }
 
-func (t SimpleTx) Cost(isTip1Fork bool) uint {
-	return 0
-}
+// Fee is what the miner of the block including the tx is paid for it.
+func (t Tx) Fee(rules Rules) (Amount, error) {
+	if !rules.IsTIP1 {
+		return NewAmount(uint64(TxFee)), nil
+	}
 
-func (t Tx) GasCost() uint {
-	return t.Gas * t.GasPrice
+	return t.GasCost()
 }
 
-func (t SimpleTx) GasCost() uint {
-	return 0
+func (t Tx) GasCost() (Amount, error) {
+	return t.GasPrice.Mul(NewAmount(uint64(t.Gas)))
 }
 
 func (t Tx) Hash() (Hash, error) {
@@ -103,21 +85,44 @@ func (t Tx) Hash() (Hash, error) {
 	return sha256.Sum256(txJson), nil
 }
 
+// Encode returns the encoding the tx is signed and hashed over, depending on its version.
 func (t Tx) Encode() ([]byte, error) {
-	return json.Marshal(t)
+	if t.Version == EncodingLegacyJSON {
+		return json.Marshal(t)
+	}
+
+	return encodeVersioned(t.Version, t.toRLP())
 }
 
-// MarshalJSON is the main source of truth for encoding a TX for hash calculation from expected attributes.
+// MarshalJSON is the main source of truth for encoding a legacy TX for hash calculation from expected attributes.
+// Since the canonical encoding, it is only used by the HTTP API.
 //
 // The logic is bit ugly and hacky but prevents infinite marshaling loops of embedded objects and allows
 // the structure to change with new TIPs.
 func (t Tx) MarshalJSON() ([]byte, error) {
+	if t.Version != EncodingLegacyJSON {
+		type versionedTx struct {
+			From     Address `json:"from"`
+			To       Address `json:"to"`
+			Gas      uint    `json:"gas"`
+			GasPrice Amount  `json:"gasPrice"`
+			Value    Amount  `json:"value"`
+			Nonce    uint    `json:"nonce"`
+			Data     string  `json:"data"`
+			Time     uint64  `json:"time"`
+			ChainID  string  `json:"chain_id,omitempty"`
+			Version  uint8   `json:"version"`
+		}
+
+		return json.Marshal(versionedTx{t.From, t.To, t.Gas, t.GasPrice, t.Value, t.Nonce, t.Data, t.Time, t.ChainID, t.Version})
+	}
+
 	// Prior TIP1
 	if t.Gas == 0 {
 		type legacyTx struct {
 			From  Address `json:"from"`
 			To    Address `json:"to"`
-			Value uint    `json:"value"`
+			Value Amount  `json:"value"`
 			Nonce uint    `json:"nonce"`
 			Data  string  `json:"data"`
 			Time  uint64  `json:"time"`
@@ -136,8 +141,8 @@ func (t Tx) MarshalJSON() ([]byte, error) {
 		From     Address `json:"from"`
 		To       Address `json:"to"`
 		Gas      uint    `json:"gas"`
-		GasPrice uint    `json:"gasPrice"`
-		Value    uint    `json:"value"`
+		GasPrice Amount  `json:"gasPrice"`
+		Value    Amount  `json:"value"`
 		Nonce    uint    `json:"nonce"`
 		Data     string  `json:"data"`
 		Time     uint64  `json:"time"`
@@ -160,12 +165,30 @@ func (t Tx) MarshalJSON() ([]byte, error) {
 // The logic is bit ugly and hacky but prevents infinite marshaling loops of embedded objects and allows
 // the structure to change with new TIPs.
 func (t SignedTx) MarshalJSON() ([]byte, error) {
+	if t.Version != EncodingLegacyJSON {
+		type versionedTx struct {
+			From     Address `json:"from"`
+			To       Address `json:"to"`
+			Gas      uint    `json:"gas"`
+			GasPrice Amount  `json:"gasPrice"`
+			Value    Amount  `json:"value"`
+			Nonce    uint    `json:"nonce"`
+			Data     string  `json:"data"`
+			Time     uint64  `json:"time"`
+			ChainID  string  `json:"chain_id,omitempty"`
+			Version  uint8   `json:"version"`
+			Sig      []byte  `json:"signature"`
+		}
+
+		return json.Marshal(versionedTx{t.From, t.To, t.Gas, t.GasPrice, t.Value, t.Nonce, t.Data, t.Time, t.ChainID, t.Version, t.Sig})
+	}
+
 	// Prior TIP1
 	if t.Gas == 0 {
 		type legacyTx struct {
 			From  Address `json:"from"`
 			To    Address `json:"to"`
-			Value uint    `json:"value"`
+			Value Amount  `json:"value"`
 			Nonce uint    `json:"nonce"`
 			Data  string  `json:"data"`
 			Time  uint64  `json:"time"`
@@ -186,8 +209,8 @@ func (t SignedTx) MarshalJSON() ([]byte, error) {
 		From     Address `json:"from"`
 		To       Address `json:"to"`
 		Gas      uint    `json:"gas"`
-		GasPrice uint    `json:"gasPrice"`
-		Value    uint    `json:"value"`
+		GasPrice Amount  `json:"gasPrice"`
+		Value    Amount  `json:"value"`
 		Nonce    uint    `json:"nonce"`
 		Data     string  `json:"data"`
 		Time     uint64  `json:"time"`
@@ -216,6 +239,26 @@ func (t SignedTx) Hash() (Hash, error) {
 	return sha256.Sum256(txJson), nil
 }
 
+// signedHash is the hash of the tx along with its signature, the leaf of the tx root of a block.
+// Hash leaves the signature out, it's the ID of the tx whoever signed it.
+func (t SignedTx) signedHash() (Hash, error) {
+	var encoded []byte
+	var err error
+
+	if t.Version == EncodingLegacyJSON {
+		encoded, err = json.Marshal(t)
+	} else {
+		encoded, err = encodeVersioned(t.Version, rlpSignedTx{t.Version, t.toRLP(), t.Sig})
+	}
+	if err != nil {
+		return Hash{}, err
+	}
+
+	return sha256.Sum256(encoded), nil
+}
+
+// IsAuthentic tells if the tx was signed by its sender. The signature covers the chain ID, which ValidateTx
+// checks is the chain's.
 func (t SignedTx) IsAuthentic() (bool, error) {
 	txHash, err := t.Tx.Hash()
 	if err != nil {
@@ -233,7 +276,3 @@ func (t SignedTx) IsAuthentic() (bool, error) {
 
 	return recoveredAccount.Hex() == t.From.Hex(), nil
 }
-
-func (t SimpleTx) IsAuthentic() (bool, error) {
-	return true, nil
-}
@@ -0,0 +1,244 @@
+package database
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// blockUndo records the balances and nonces the accounts changed by a block had before it was applied,
+// the supply before it and the mint ledger if the block changed it.
+type blockUndo struct {
+	Hash     Hash               `json:"hash"`
+	Balances map[Address]Amount `json:"balances"`
+	Nonces   map[Address]uint   `json:"nonces"`
+
+	// Minted is the mint ledger before the block, only set if the block minted tokens
+	Minted *MintLedger `json:"minted,omitempty"`
+
+	// Supply is the supply before the block, missing from records written before the supply was tracked
+	Supply *Supply `json:"supply,omitempty"`
+}
+
+func newBlockUndo(hash Hash, before *State, after *State) blockUndo {
+	supply := before.supply
+	undo := blockUndo{hash, make(map[Address]Amount), make(map[Address]uint), nil, &supply}
+
+	for account, balance := range after.Balances {
+		if before.Balances[account] != balance {
+			undo.Balances[account] = before.Balances[account]
+		}
+	}
+
+	for account, nonce := range after.Account2Nonce {
+		if before.Account2Nonce[account] != nonce {
+			undo.Nonces[account] = before.Account2Nonce[account]
+		}
+	}
+
+	if before.minted != after.minted {
+		minted := before.minted
+		undo.Minted = &minted
+	}
+
+	return undo
+}
+
+// revert restores the accounts of the state to what they were before the block was applied.
+func (u blockUndo) revert(s *State) {
+	for account, balance := range u.Balances {
+		if balance.IsZero() {
+			delete(s.Balances, account)
+		} else {
+			s.Balances[account] = balance
+		}
+	}
+
+	if u.Minted != nil {
+		s.minted = *u.Minted
+	}
+
+	s.supply = *u.Supply
+
+	for account, nonce := range u.Nonces {
+		if nonce == 0 {
+			delete(s.Account2Nonce, account)
+		} else {
+			s.Account2Nonce[account] = nonce
+		}
+	}
+}
+
+// undoJournal keeps the undo record of every applied block keyed by height, so the state can be rolled back
+// block by block instead of being replayed from genesis or a snapshot.
+type undoJournal struct {
+	db *leveldb.DB
+}
+
+func openUndoJournal(path string) (*undoJournal, error) {
+	db, err := leveldb.OpenFile(path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &undoJournal{db}, nil
+}
+
+func undoKey(height uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, height)
+}
+
+func (j *undoJournal) put(height uint64, undo blockUndo) error {
+	undoJson, err := json.Marshal(undo)
+	if err != nil {
+		return err
+	}
+
+	return j.db.Put(undoKey(height), undoJson, &opt.WriteOptions{Sync: true})
+}
+
+func (j *undoJournal) get(height uint64) (blockUndo, bool, error) {
+	var undo blockUndo
+
+	undoJson, err := j.db.Get(undoKey(height), nil)
+	if err == leveldb.ErrNotFound {
+		return undo, false, nil
+	}
+	if err != nil {
+		return undo, false, err
+	}
+
+	err = json.Unmarshal(undoJson, &undo)
+	if err != nil {
+		return undo, false, err
+	}
+
+	return undo, true, nil
+}
+
+// deleteFrom removes the undo records of every block from the given height on.
+func (j *undoJournal) deleteFrom(height uint64) error {
+	batch := new(leveldb.Batch)
+
+	it := j.db.NewIterator(&util.Range{Start: undoKey(height)}, nil)
+	for it.Next() {
+		batch.Delete(append([]byte{}, it.Key()...))
+	}
+	it.Release()
+
+	if err := it.Error(); err != nil {
+		return err
+	}
+
+	return j.db.Write(batch, &opt.WriteOptions{Sync: true})
+}
+
+func (j *undoJournal) close() error {
+	return j.db.Close()
+}
+
+// revertedTo returns a copy of the state rolled back to right after the block at the given height,
+// undoing the later blocks one by one. The chain is replayed instead if an undo record is missing.
+func (s *State) revertedTo(height uint64) (*State, error) {
+	if !s.hasGenesisBlock || height > s.latestBlock.Header.Number {
+		return nil, fmt.Errorf("block %d is not part of the chain", height)
+	}
+
+	if s.undo == nil {
+		return s.stateAt(height)
+	}
+
+	reverted := s.copy()
+
+	for h := s.latestBlock.Header.Number; h > height; h-- {
+		blockFs, err := s.store.BlockByHeight(h)
+		if err != nil {
+			return nil, err
+		}
+
+		undo, ok, err := s.undo.get(h)
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok || undo.Hash != blockFs.Key || undo.Supply == nil {
+			fmt.Printf("No undo record of block %d, replaying the chain up to block %d\n", h, height)
+			return s.stateAt(height)
+		}
+
+		undo.revert(&reverted)
+		reverted.work = new(big.Int).Sub(reverted.work, blockWork(blockFs.Key))
+	}
+
+	blockFs, err := s.store.BlockByHeight(height)
+	if err != nil {
+		return nil, err
+	}
+
+	reverted.latestBlock = blockFs.Value
+	reverted.latestBlockHash = blockFs.Key
+
+	reverted.recentTimes, err = loadRecentTimes(s.store, height)
+	if err != nil {
+		return nil, err
+	}
+
+	return &reverted, nil
+}
+
+// RollbackTo reverts the state to right after the block at the given height and removes every later block
+// from the chain, along with their undo records and the snapshots taken of them. It returns the removed blocks.
+func (s *State) RollbackTo(height uint64) ([]Block, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	reverted, err := s.revertedTo(height)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make([]Block, 0)
+	err = s.store.ForEach(height+1, func(blockFs BlockFS) error {
+		removed = append(removed, blockFs.Value)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.store.TruncateFrom(height + 1); err != nil {
+		return nil, err
+	}
+
+	if s.undo != nil {
+		if err := s.undo.deleteFrom(height + 1); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := removeSnapshotsFrom(s.dataDir, height+1); err != nil {
+		return nil, err
+	}
+
+	if s.index != nil {
+		if err := s.index.unindexBlocks(removed, BlockFS{reverted.latestBlockHash, reverted.latestBlock}); err != nil {
+			return nil, err
+		}
+	}
+
+	s.Balances = reverted.Balances
+	s.Account2Nonce = reverted.Account2Nonce
+	s.minted = reverted.minted
+	s.supply = reverted.supply
+	s.recentTimes = reverted.recentTimes
+	s.work = reverted.work
+	s.latestBlock = reverted.latestBlock
+	s.latestBlockHash = reverted.latestBlockHash
+
+	return removed, nil
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"testing"
+)
+
+func TestRollbackTo(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+
+	state, dataDir := newTestState(t, map[Address]Amount{andrej.addr: NewAmount(1000)})
+
+	for i := uint(1); i <= 4; i++ {
+		tx := andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(100), i, ""))
+		if _, err := state.AddBlock(mineTestBlock(t, state, babaYaga.addr, []SignedTx{tx})); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	block1, err := state.GetBlockByHeightOrHash(1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	view1, err := state.ViewAt(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	removed, err := state.RollbackTo(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(removed) != 2 || state.LatestBlockHash() != block1.Key {
+		t.Fatalf("rollback should remove 2 blocks and make block 1 the tip, removed %d", len(removed))
+	}
+
+	if state.StateRoot() != block1.Value.Header.StateRoot || state.Account2Nonce[andrej.addr] != 2 {
+		t.Fatal("rolled back state should match the state root of block 1")
+	}
+
+	if state.TotalWork().Cmp(view1.Work()) != 0 {
+		t.Fatalf("rolled back total work should be %s, not %s", view1.Work(), state.TotalWork())
+	}
+
+	if _, err := state.GetBlockByHeightOrHash(2, ""); err == nil {
+		t.Fatal("blocks after the rollback height should be removed from the store")
+	}
+
+	// the chain can grow again from the new tip
+	tx := andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(50), 3, ""))
+	if _, err := state.AddBlock(mineTestBlock(t, state, babaYaga.addr, []SignedTx{tx})); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := state.StateRoot()
+	state.Close()
+
+	reloaded, err := NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reloaded.Close()
+
+	if reloaded.StateRoot() != expected || reloaded.LatestBlock().Header.Number != 2 {
+		t.Fatal("rolled back chain should be persisted")
+	}
+
+	// without undo records the state is replayed instead
+	if err := reloaded.undo.deleteFrom(0); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := reloaded.RollbackTo(0); err != nil {
+		t.Fatal(err)
+	}
+
+	block0, err := reloaded.GetBlockByHeightOrHash(0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reloaded.StateRoot() != block0.Value.Header.StateRoot {
+		t.Fatal("replayed state should match the state root of block 0")
+	}
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"fmt"
+)
+
+// ChainError reports the first stored block failing verification.
+type ChainError struct {
+	Height uint64
+	Hash   Hash
+	Reason string
+}
+
+func (e *ChainError) Error() string {
+	return fmt.Sprintf("block %d '%s' is invalid: %s", e.Height, e.Hash.Hex(), e.Reason)
+}
+
+// VerifyChain re-validates every block of the data dir from genesis, ignoring snapshots, and returns
+// the number of valid blocks. The first invalid block is reported as a *ChainError.
+//
+// Besides the rules enforced when blocks are added, it checks the stored hash matches the block content,
+// every block links to its parent and the miner reward and mint transactions are the only new money a block creates.
+func VerifyChain(dataDir string) (uint64, error) {
+	gen, err := loadGenesis(getGenesisJsonFilePath(dataDir))
+	if err != nil {
+		return 0, err
+	}
+
+	store, err := OpenBlockStore(dataDir)
+	if err != nil {
+		return 0, err
+	}
+	defer store.Close()
+
+	miningDifficulty := gen.Difficulty
+	state := newGenesisState(dataDir, gen, store, miningDifficulty)
+	verified := uint64(0)
+
+	err = store.ForEach(0, func(blockFs BlockFS) error {
+		b := blockFs.Value
+
+		invalid := func(format string, a ...interface{}) error {
+			return &ChainError{b.Header.Number, blockFs.Key, fmt.Sprintf(format, a...)}
+		}
+
+		hash, err := b.Hash()
+		if err != nil {
+			return invalid("unable to hash the block. %s", err.Error())
+		}
+
+		if hash != blockFs.Key {
+			return invalid("stored hash doesn't match the block content hash '%s'", hash.Hex())
+		}
+
+		if !state.hasGenesisBlock {
+			if b.Header.Number != 0 || !b.Header.Parent.IsEmpty() {
+				return invalid("the first block must have height 0 and no parent")
+			}
+		} else {
+			if b.Header.Number != state.latestBlock.Header.Number+1 {
+				return invalid("height must be %d", state.latestBlock.Header.Number+1)
+			}
+
+			if b.Header.Parent != state.latestBlockHash {
+				return invalid("parent must be '%s' not '%s'", state.latestBlockHash.Hex(), b.Header.Parent.Hex())
+			}
+		}
+
+		isLegacy, err := state.checkLegacyBlock(b, hash)
+		if err != nil {
+			return invalid("%s", err.Error())
+		}
+
+		if isLegacy {
+			// the legacy transfers only move the old genesis balances around, besides the legacy mints
+			if err := applyLegacyBlock(b, state); err != nil {
+				return invalid("%s", err.Error())
+			}
+		} else {
+			if !IsBlockHashValid(hash, miningDifficulty) {
+				return invalid("hash doesn't satisfy the mining difficulty %d", miningDifficulty)
+			}
+
+			if err := state.checkBlockTime(b); err != nil {
+				return invalid("%s", err.Error())
+			}
+
+			if err := state.checkBlockEncoding(b); err != nil {
+				return invalid("%s", err.Error())
+			}
+
+			// besides the miner reward, only the mint transactions of authorities create tokens
+			if err := applyBlockBody(b, state); err != nil {
+				return invalid("%s", err.Error())
+			}
+		}
+		state.recentTimes = withBlockTime(state.recentTimes, b.Header.Time)
+
+		state.latestBlock = b
+		state.latestBlockHash = blockFs.Key
+		state.hasGenesisBlock = true
+		verified++
+
+		return nil
+	})
+
+	return verified, err
+}
@@ -0,0 +1,62 @@
+package database
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jnsoft/gamma/util/security"
+)
+
+func TestVerifyChain(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+
+	state, dataDir := newTestState(t, map[Address]Amount{andrej.addr: NewAmount(1000)})
+
+	for i := uint(1); i <= 3; i++ {
+		tx := andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(10), i, ""))
+
+		if _, err := state.AddBlock(mineTestBlock(t, state, babaYaga.addr, []SignedTx{tx})); err != nil {
+			t.Fatal(err)
+		}
+	}
+	state.Close()
+
+	verified, err := VerifyChain(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if verified != 3 {
+		t.Fatalf("expected 3 verified blocks, got %d", verified)
+	}
+
+	// a block appended behind the node's back, not linked to its parent
+	store, err := OpenBlockStore(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	orphan := NewBlock(Hash{}, 3, 0, 0, babaYaga.addr, nil)
+	var orphanHash Hash
+	for !IsBlockHashValid(orphanHash, testMiningDifficulty) {
+		orphan.Header.Nonce = security.GenerateNonce()
+		orphanHash, _ = orphan.Hash()
+	}
+
+	if err := store.Append(BlockFS{orphanHash, orphan}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	verified, err = VerifyChain(dataDir)
+
+	var chainErr *ChainError
+	if !errors.As(err, &chainErr) {
+		t.Fatalf("expected a chain error, got %v", err)
+	}
+
+	if chainErr.Height != 3 || chainErr.Hash != orphanHash || verified != 3 {
+		t.Fatalf("expected block 3 to fail after 3 verified blocks, got block %d after %d", chainErr.Height, verified)
+	}
+}
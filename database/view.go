@@ -0,0 +1,107 @@
+package database
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// StateView is a read-only snapshot of the balances, nonces and supply right after a block.
+//
+// It stays consistent while blocks are added to the state it was taken of, so concurrent readers such as
+// API handlers read it without locking. The maps it returns are shared and must not be modified.
+type StateView struct {
+	blockHash Hash
+	height    uint64
+	balances  map[Address]Amount
+	nonces    map[Address]uint
+	supply    Supply
+	work      *big.Int
+}
+
+// View returns a snapshot of the state at the latest block. Taking it doesn't copy the accounts.
+func (s *State) View() StateView {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return StateView{s.latestBlockHash, s.latestBlock.Header.Number, s.Balances, s.Account2Nonce, s.supply, s.work}
+}
+
+func (v StateView) BlockHash() Hash {
+	return v.blockHash
+}
+
+func (v StateView) Height() uint64 {
+	return v.height
+}
+
+func (v StateView) Balance(account Address) Amount {
+	return v.balances[account]
+}
+
+func (v StateView) Nonce(account Address) uint {
+	return v.nonces[account]
+}
+
+func (v StateView) Supply() Supply {
+	return v.supply
+}
+
+// Work is the total work of the chain up to the block, it must not be modified.
+func (v StateView) Work() *big.Int {
+	return v.work
+}
+
+// Balances returns the balance of every account, the map must not be modified.
+func (v StateView) Balances() map[Address]Amount {
+	return v.balances
+}
+
+// Nonces returns the nonce of every account, the map must not be modified.
+func (v StateView) Nonces() map[Address]uint {
+	return v.nonces
+}
+
+// ViewAt returns a snapshot of the state right after the main chain block at the given height.
+//
+// Past states are replayed from the nearest snapshot at or below the height, or from the genesis without one.
+// The blocks to replay are read under the state lock, they're applied after it's released.
+func (s *State) ViewAt(height uint64) (StateView, error) {
+	s.mu.RLock()
+	replay, err := s.replayAt(height)
+	s.mu.RUnlock()
+	if err != nil {
+		return StateView{}, err
+	}
+
+	return replay.view()
+}
+
+// ViewAtHash returns a snapshot of the state right after the main chain block with the given hash.
+func (s *State) ViewAtHash(hash Hash) (StateView, error) {
+	s.mu.RLock()
+	replay, err := s.replayAtHash(hash)
+	s.mu.RUnlock()
+	if err != nil {
+		return StateView{}, err
+	}
+
+	return replay.view()
+}
+
+func (s *State) replayAtHash(hash Hash) (stateReplay, error) {
+	blockFs, err := s.store.BlockByHash(hash)
+	if err != nil {
+		return stateReplay{}, fmt.Errorf("block '%s' is not part of the chain", hash.Hex())
+	}
+
+	return s.replayAt(blockFs.Value.Header.Number)
+}
+
+func (r stateReplay) view() (StateView, error) {
+	state, err := r.run()
+	if err != nil {
+		return StateView{}, err
+	}
+
+	return StateView{state.latestBlockHash, state.latestBlock.Header.Number, state.Balances, state.Account2Nonce, state.supply, state.work}, nil
+}
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// TestStateConcurrentReads reads the state while blocks are added and rolled back, run it with -race.
+func TestStateConcurrentReads(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+
+	state, _ := newTestState(t, map[Address]Amount{andrej.addr: NewAmount(1000)})
+
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+
+			for {
+				select {
+				case <-done:
+					return
+				default:
+				}
+
+				// every view is the state right after one block, the supply is the genesis plus a reward per block
+				view := state.View()
+				if view.BlockHash().IsEmpty() {
+					continue
+				}
+
+				supply := NewAmount(0)
+				for _, balance := range view.Balances() {
+					supply, _ = supply.Add(balance)
+				}
+
+				expected := NewAmount(1000 + (view.Height()+1)*BlockReward)
+				if supply != expected {
+					t.Errorf("view of block %d should hold %s TGL, not %s", view.Height(), expected, supply)
+					return
+				}
+
+				// blocks after 2 may be rolled back once the view is taken, the earlier ones stay part of the chain
+				if view.Height() <= 2 {
+					blockFs, err := state.GetBlockByHeightOrHash(view.Height(), "")
+					if err != nil || blockFs.Key != view.BlockHash() {
+						t.Errorf("block %d of the view should be readable, got %v", view.Height(), err)
+						return
+					}
+				}
+
+				// a page never mixes blocks of the branch rolled back with the ones replacing it
+				parent := Hash{}
+				_, err := state.ForEachBlockAfter(Hash{}, 10, func(blockFs BlockFS) error {
+					if blockFs.Value.Header.Parent != parent {
+						return fmt.Errorf("block %d doesn't follow the previous block of the page", blockFs.Value.Header.Number)
+					}
+					parent = blockFs.Key
+
+					return nil
+				})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+
+				// past states are replayed once the lock is released, while blocks keep being added
+				if view.Height() >= 1 {
+					if _, err := state.ViewAt(1); err != nil {
+						t.Error(err)
+						return
+					}
+
+					if _, err := state.GetAccountProof(andrej.addr, 1); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+
+				if _, _, err := state.GetAccountHistory(andrej.addr, 0, 10); err != nil {
+					t.Error(err)
+					return
+				}
+
+				state.StateRoot()
+				state.Copy()
+				state.GetNextAccountNonce(andrej.addr)
+			}
+		}()
+	}
+
+	addBlocks := func(count int) {
+		for i := 0; i < count; i++ {
+			tx := andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(10), state.GetNextAccountNonce(andrej.addr), ""))
+
+			if _, err := state.AddBlock(mineTestBlock(t, state, babaYaga.addr, []SignedTx{tx})); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	addBlocks(6)
+
+	if _, err := state.RollbackTo(2); err != nil {
+		t.Fatal(err)
+	}
+
+	addBlocks(3)
+
+	close(done)
+	readers.Wait()
+
+	if state.LatestBlock().Header.Number != 5 {
+		t.Fatalf("chain should end at block 5, not %d", state.LatestBlock().Header.Number)
+	}
+}
+
+func TestStateViewAt(t *testing.T) {
+	andrej := newTestAccount(t)
+	babaYaga := newTestAccount(t)
+
+	state, _ := newTestState(t, map[Address]Amount{andrej.addr: NewAmount(1000)})
+
+	var views []StateView
+	for i := uint(1); i <= 4; i++ {
+		tx := andrej.sign(t, NewBaseTx(andrej.addr, babaYaga.addr, NewAmount(10), i, ""))
+
+		if _, err := state.AddBlock(mineTestBlock(t, state, babaYaga.addr, []SignedTx{tx})); err != nil {
+			t.Fatal(err)
+		}
+
+		views = append(views, state.View())
+
+		// a snapshot in the middle of the chain is the checkpoint later heights are replayed from
+		if i == 2 {
+			if _, err := state.CreateSnapshot(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	for height, expected := range views {
+		view, err := state.ViewAt(uint64(height))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if view.BlockHash() != expected.BlockHash() || view.Balance(babaYaga.addr) != expected.Balance(babaYaga.addr) || view.Nonce(andrej.addr) != uint(height+1) {
+			t.Fatalf("view at block %d should match the state right after it, got baba yaga %s and nonce %d", height, view.Balance(babaYaga.addr), view.Nonce(andrej.addr))
+		}
+
+		byHash, err := state.ViewAtHash(expected.BlockHash())
+		if err != nil || byHash.Height() != uint64(height) {
+			t.Fatalf("view at block '%s' should be at height %d, got %d and %v", expected.BlockHash().Hex(), height, byHash.Height(), err)
+		}
+	}
+
+	if _, err := state.ViewAt(4); err == nil {
+		t.Fatal("view after the latest block should fail")
+	}
+}
@@ -1,8 +1,10 @@
 package node
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"math/big"
 	"net/http"
 	"strconv"
 	"strings"
@@ -17,35 +19,87 @@ type ErrRes struct {
 }
 
 type BalancesRes struct {
-	Hash     database.Hash             `json:"block_hash"`
-	Balances map[database.Address]uint `json:"balances"`
+	Hash     database.Hash                        `json:"block_hash"`
+	Height   uint64                               `json:"block_height"`
+	Balances map[database.Address]database.Amount `json:"balances"`
+}
+
+type AccountRes struct {
+	Hash    database.Hash    `json:"block_hash"`
+	Height  uint64           `json:"block_height"`
+	Account database.Address `json:"account"`
+	Balance database.Amount  `json:"balance"`
+	Nonce   uint             `json:"nonce"`
+}
+
+type SupplyRes struct {
+	Hash   database.Hash   `json:"block_hash"`
+	Height uint64          `json:"block_height"`
+	Supply database.Supply `json:"supply"`
 }
 
 type TxAddReq struct {
-	From     string `json:"from"`
-	FromPwd  string `json:"from_pwd"`
-	To       string `json:"to"`
-	Gas      uint   `json:"gas"`
-	GasPrice uint   `json:"gasPrice"`
-	Value    uint   `json:"value"`
-	Data     string `json:"data"`
+	From     string          `json:"from"`
+	FromPwd  string          `json:"from_pwd"`
+	To       string          `json:"to"`
+	Gas      uint            `json:"gas"`
+	GasPrice database.Amount `json:"gasPrice"`
+	Value    database.Amount `json:"value"`
+	Data     string          `json:"data"`
 }
 
 type TxAddRes struct {
 	Success bool `json:"success"`
 }
 
+const (
+	txStatusPending   = "pending"
+	txStatusConfirmed = "confirmed"
+)
+
+type TxRes struct {
+	Tx            database.SignedTx `json:"tx"`
+	Status        string            `json:"status"`
+	BlockHash     database.Hash     `json:"block_hash"`
+	BlockHeight   uint64            `json:"block_height"`
+	Index         int               `json:"index"`
+	Confirmations uint64            `json:"confirmations"`
+}
+
+type AccountTxsRes struct {
+	Account database.Address     `json:"account"`
+	Total   int                  `json:"total"`
+	Offset  int                  `json:"offset"`
+	Limit   int                  `json:"limit"`
+	Txs     []database.AccountTx `json:"txs"`
+}
+
 type StatusRes struct {
 	Hash        database.Hash       `json:"block_hash"`
 	Number      uint64              `json:"block_number"`
+	Work        *big.Int            `json:"chain_work,omitempty"` // missing from peers which compare chains by height
 	KnownPeers  map[string]PeerNode `json:"peers_known"`
 	PendingTXs  []database.SignedTx `json:"pending_txs"`
 	NodeVersion string              `json:"node_version"`
 	Account     database.Address    `json:"account"`
+	ChainID     string              `json:"chain_id"`
+	GenesisHash database.Hash       `json:"genesis_hash"`
+}
+
+// MintedRes reports the tokens created by mint transactions. Policy is null if the genesis authorizes no minting.
+type MintedRes struct {
+	Hash         database.Hash        `json:"block_hash"`
+	Policy       *database.MintPolicy `json:"policy"`
+	Total        database.Amount      `json:"total"`
+	Period       uint64               `json:"period"`
+	PeriodMinted database.Amount      `json:"period_minted"`
 }
 
+// SyncRes is a page of the blocks after a cursor. The cursor of the next page is the hash of the last block.
 type SyncRes struct {
 	Blocks []database.Block `json:"blocks"`
+	Cursor database.Hash    `json:"cursor"`
+	More   bool             `json:"more"`
 }
 
 type AddPeerRes struct {
@@ -53,10 +107,68 @@ type AddPeerRes struct {
 	Error   string `json:"error"`
 }
 
+// listBalancesHandler serves /balances/list with the balances at the latest block, or at the block
+// given by ?height={height} or ?hash={hash}.
 func listBalancesHandler(w http.ResponseWriter, r *http.Request, state *database.State) {
 	enableCors(&w)
 
-	writeRes(w, BalancesRes{state.LatestBlockHash(), state.Balances})
+	view, err := viewFromQuery(r, state)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, BalancesRes{view.BlockHash(), view.Height(), view.Balances()})
+}
+
+// viewFromQuery returns the state view at the block given by the height or hash query parameter, the latest one by default.
+func viewFromQuery(r *http.Request, state *database.State) (database.StateView, error) {
+	heightRaw := r.URL.Query().Get(endpointBalancesQueryKeyHeight)
+	hashRaw := r.URL.Query().Get(endpointBalancesQueryKeyHash)
+
+	switch {
+	case heightRaw != "" && hashRaw != "":
+		return database.StateView{}, fmt.Errorf("expected either '%s' or '%s', not both", endpointBalancesQueryKeyHeight, endpointBalancesQueryKeyHash)
+	case heightRaw != "":
+		height, err := strconv.ParseUint(heightRaw, 10, 64)
+		if err != nil {
+			return database.StateView{}, fmt.Errorf("invalid height '%s'", heightRaw)
+		}
+
+		return state.ViewAt(height)
+	case hashRaw != "":
+		var hash database.Hash
+		if len(hashRaw) != 2*database.HashLength || hash.UnmarshalText([]byte(hashRaw)) != nil {
+			return database.StateView{}, fmt.Errorf("invalid hash '%s'", hashRaw)
+		}
+
+		return state.ViewAtHash(hash)
+	}
+
+	// a view stays consistent while the sync and mining goroutines add blocks
+	return state.View(), nil
+}
+
+func mintedHandler(w http.ResponseWriter, r *http.Request, state *database.State) {
+	enableCors(&w)
+
+	minted := state.Minted()
+
+	writeRes(w, MintedRes{state.LatestBlockHash(), state.MintPolicy(), minted.Total, minted.Period, minted.PeriodMinted})
+}
+
+// supplyHandler serves /supply with the circulating supply and the rewarded, minted and fee totals at the latest
+// block, or at the block given by ?height={height} or ?hash={hash}.
+func supplyHandler(w http.ResponseWriter, r *http.Request, state *database.State) {
+	enableCors(&w)
+
+	view, err := viewFromQuery(r, state)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, SupplyRes{view.BlockHash(), view.Height(), view.Supply()})
 }
 
 func txAddHandler(w http.ResponseWriter, r *http.Request, node *Node) {
@@ -69,7 +181,7 @@ func txAddHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 
 	from := database.NewAccount(req.From)
 
-	if from.String() == common.HexToAddress("").String() {
+	if from == (database.Address{}) {
 		writeErrRes(w, fmt.Errorf("%s is an invalid 'from' sender", from.String()))
 		return
 	}
@@ -81,6 +193,7 @@ func txAddHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 
 	nonce := node.state.GetNextAccountNonce(from)
 	tx := database.NewTx(from, database.NewAccount(req.To), req.Gas, req.GasPrice, req.Value, nonce, req.Data)
+	tx.ChainID = node.state.ChainID()
 
 	signedTx, err := wallet.SignTxWithKeystoreAccount(tx, from, req.FromPwd, wallet.GetKeystoreDirPath(node.dataDir))
 	if err != nil {
@@ -100,18 +213,27 @@ func txAddHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 func statusHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 	enableCors(&w)
 
+	view := node.state.View()
+
 	res := StatusRes{
-		Hash:        node.state.LatestBlockHash(),
-		Number:      node.state.LatestBlock().Header.Number,
+		Hash:        view.BlockHash(),
+		Number:      view.Height(),
+		Work:        view.Work(),
 		KnownPeers:  node.knownPeers,
 		PendingTXs:  node.getPendingTXsAsArray(),
 		NodeVersion: node.nodeVersion,
-		Account:     database.NewAccount(node.info.Account.String()),
+		Account:     node.info.Account,
+		ChainID:     node.state.ChainID(),
+		GenesisHash: node.state.GenesisHash(),
 	}
 
 	writeRes(w, res)
 }
 
+// syncHandler serves /node/sync?fromBlock={hash}&limit={limit} with a SyncRes page of the blocks after the cursor hash.
+//
+// The page is read from the block store at once, then each block is encoded and flushed to the peer in turn,
+// so the response itself is never buffered.
 func syncHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 	reqHash := r.URL.Query().Get(endpointSyncQueryKeyFromBlock)
 
@@ -122,13 +244,66 @@ func syncHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 		return
 	}
 
-	blocks, err := database.GetBlocksAfter(hash, node.dataDir)
+	limit, err := queryInt(r, endpointSyncQueryKeyLimit, syncPageDefaultLimit)
 	if err != nil {
 		writeErrRes(w, err)
 		return
 	}
+	if limit < 1 || limit > syncPageMaxLimit {
+		writeErrRes(w, fmt.Errorf("limit must be between 1 and %d, not %d", syncPageMaxLimit, limit))
+		return
+	}
 
-	writeRes(w, SyncRes{Blocks: blocks})
+	flusher, _ := w.(http.Flusher)
+
+	// the status is only sent along with the first block, so a page which can't be read is answered with an error
+	started := false
+	start := func() {
+		if !started {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			fmt.Fprint(w, `{"blocks":[`)
+			started = true
+		}
+	}
+
+	cursor := hash
+	sent := 0
+	more, err := node.state.ForEachBlockAfter(hash, limit, func(blockFs database.BlockFS) error {
+		blockJson, err := json.Marshal(blockFs.Value)
+		if err != nil {
+			return err
+		}
+
+		start()
+		if sent > 0 {
+			w.Write([]byte(","))
+		}
+		cursor = blockFs.Key
+		sent++
+
+		if _, err := w.Write(blockJson); err != nil {
+			return err
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		return nil
+	})
+	if err != nil && !started {
+		writeErrRes(w, err)
+		return
+	}
+	if err != nil {
+		// the status is already sent, leaving the document unterminated makes the peer reject it
+		fmt.Printf("ERROR: unable to stream the blocks after '%s'. %s\n", hash.Hex(), err.Error())
+		return
+	}
+
+	start()
+	fmt.Fprintf(w, `],"cursor":"%s","more":%t}`, cursor.Hex(), more)
 }
 
 func addPeerHandler(w http.ResponseWriter, r *http.Request, node *Node) {
@@ -136,6 +311,7 @@ func addPeerHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 	peerPortRaw := r.URL.Query().Get(endpointAddPeerQueryKeyPort)
 	minerRaw := r.URL.Query().Get(endpointAddPeerQueryKeyMiner)
 	versionRaw := r.URL.Query().Get(endpointAddPeerQueryKeyVersion)
+	genesisRaw := r.URL.Query().Get(endpointAddPeerQueryKeyGenesis)
 
 	peerPort, err := strconv.ParseUint(peerPortRaw, 10, 32)
 	if err != nil {
@@ -143,6 +319,18 @@ func addPeerHandler(w http.ResponseWriter, r *http.Request, node *Node) {
 		return
 	}
 
+	genesisHash := database.Hash{}
+	err = genesisHash.UnmarshalText([]byte(genesisRaw))
+	if err != nil {
+		writeRes(w, AddPeerRes{false, err.Error()})
+		return
+	}
+
+	if genesisHash != node.state.GenesisHash() {
+		writeRes(w, AddPeerRes{false, fmt.Sprintf("peer genesis '%s' differs from genesis '%s'", genesisHash.Hex(), node.state.GenesisHash().Hex())})
+		return
+	}
+
 	peer := NewPeerNode(peerIP, peerPort, false, database.NewAccount(minerRaw), true, versionRaw)
 
 	node.AddPeer(peer)
@@ -174,7 +362,7 @@ func blockByNumberOrHash(w http.ResponseWriter, r *http.Request, node *Node) {
 		hsh = p
 	}
 
-	block, err := database.GetBlockByHeightOrHash(node.state, height, hsh, node.dataDir)
+	block, err := node.state.GetBlockByHeightOrHash(height, hsh)
 	if err != nil {
 		writeErrRes(w, err)
 		return
@@ -183,6 +371,157 @@ func blockByNumberOrHash(w http.ResponseWriter, r *http.Request, node *Node) {
 	writeRes(w, block)
 }
 
+// txHandler serves /tx/{hash} with the tx and where it was mined, or its pending status,
+// and /tx/{hash}/proof with the Merkle proof of the tx being included in its block.
+func txHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	enableCors(&w)
+
+	params := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(params) < 2 || len(params) > 3 || (len(params) == 3 && params[2] != "proof") {
+		writeErrRes(w, errors.New("expected /tx/{hash} or /tx/{hash}/proof"))
+		return
+	}
+
+	var txHash database.Hash
+	if len(params[1]) != 2*database.HashLength || txHash.UnmarshalText([]byte(params[1])) != nil {
+		writeErrRes(w, fmt.Errorf("invalid tx hash '%s'", params[1]))
+		return
+	}
+
+	if len(params) == 3 {
+		proof, err := node.state.GetTxProof(txHash)
+		if err != nil {
+			writeErrRes(w, err)
+			return
+		}
+
+		writeRes(w, proof)
+		return
+	}
+
+	if tx, isPending := node.pendingTXs[txHash.Hex()]; isPending {
+		writeRes(w, TxRes{Tx: tx, Status: txStatusPending})
+		return
+	}
+
+	lookup, ok, err := node.state.GetTx(txHash)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	if !ok {
+		writeErrRes(w, fmt.Errorf("tx '%s' is neither pending nor part of the chain", txHash.Hex()))
+		return
+	}
+
+	writeRes(w, TxRes{lookup.Tx, txStatusConfirmed, lookup.BlockHash, lookup.BlockHeight, lookup.Index, lookup.Confirmations})
+}
+
+// accountHandler serves /account/{addr}, /account/{addr}/proof and /account/{addr}/txs.
+func accountHandler(w http.ResponseWriter, r *http.Request, node *Node) {
+	enableCors(&w)
+
+	params := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(params) < 2 || len(params) > 3 || (len(params) == 3 && params[2] != "proof" && params[2] != "txs") {
+		writeErrRes(w, errors.New("expected /account/{addr}, /account/{addr}/proof or /account/{addr}/txs"))
+		return
+	}
+
+	if !common.IsHexAddress(params[1]) {
+		writeErrRes(w, fmt.Errorf("invalid account '%s'", params[1]))
+		return
+	}
+	account := database.NewAccount(params[1])
+
+	switch {
+	case len(params) == 2:
+		accountStateHandler(w, r, node, account)
+	case params[2] == "proof":
+		accountProofHandler(w, r, node, account)
+	default:
+		accountTxsHandler(w, r, node, account)
+	}
+}
+
+// accountStateHandler serves /account/{addr}?height={height} with the account balance and nonce at the block
+// given by the height or hash query parameter, the latest one by default.
+func accountStateHandler(w http.ResponseWriter, r *http.Request, node *Node, account database.Address) {
+	view, err := viewFromQuery(r, node.state)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, AccountRes{view.BlockHash(), view.Height(), account, view.Balance(account), view.Nonce(account)})
+}
+
+// accountProofHandler serves /account/{addr}/proof?height={height} with the Merkle proof of the account balance
+// and nonce against the state root of the block at the given height, the latest block by default.
+func accountProofHandler(w http.ResponseWriter, r *http.Request, node *Node, account database.Address) {
+	height := node.state.LatestBlock().Header.Number
+	if heightRaw := r.URL.Query().Get(endpointAccountQueryKeyHeight); heightRaw != "" {
+		var err error
+		height, err = strconv.ParseUint(heightRaw, 10, 64)
+		if err != nil {
+			writeErrRes(w, fmt.Errorf("invalid height '%s'", heightRaw))
+			return
+		}
+	}
+
+	proof, err := node.state.GetAccountProof(account, height)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, proof)
+}
+
+// accountTxsHandler serves /account/{addr}/txs?offset={offset}&limit={limit} with a page of the transfers
+// touching the account, newest first.
+func accountTxsHandler(w http.ResponseWriter, r *http.Request, node *Node, account database.Address) {
+	offset, err := queryInt(r, endpointAccountQueryKeyOffset, 0)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	limit, err := queryInt(r, endpointAccountQueryKeyLimit, accountTxsDefaultLimit)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	if limit < 1 || limit > accountTxsMaxLimit {
+		writeErrRes(w, fmt.Errorf("limit must be between 1 and %d", accountTxsMaxLimit))
+		return
+	}
+
+	txs, total, err := node.state.GetAccountHistory(account, offset, limit)
+	if err != nil {
+		writeErrRes(w, err)
+		return
+	}
+
+	writeRes(w, AccountTxsRes{account, total, offset, limit, txs})
+}
+
+// queryInt reads a non negative int query param, or returns the default value if it's missing.
+func queryInt(r *http.Request, key string, defaultValue int) (int, error) {
+	raw := r.URL.Query().Get(key)
+	if raw == "" {
+		return defaultValue, nil
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 {
+		return 0, fmt.Errorf("invalid %s '%s'", key, raw)
+	}
+
+	return value, nil
+}
+
 func mempoolViewer(w http.ResponseWriter, r *http.Request, txs map[string]database.SignedTx) {
 	enableCors(&w)
 
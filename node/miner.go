@@ -6,21 +6,28 @@ import (
 
 	"time"
 
-	"github.com/ethereum/go-ethereum/common"
 	"github.com/jnsoft/gamma/database"
 	"github.com/jnsoft/gamma/util/security"
 )
 
 type PendingBlock struct {
-	parent database.Hash
-	number uint64
-	time   uint64
-	miner  common.Address
-	txs    []database.SignedTx
+	parent    database.Hash
+	number    uint64
+	time      uint64
+	miner     database.Address
+	txs       []database.SignedTx
+	stateRoot database.Hash
 }
 
-func NewPendingBlock(parent database.Hash, number uint64, miner common.Address, txs []database.SignedTx) PendingBlock {
-	return PendingBlock{parent, number, uint64(time.Now().Unix()), miner, txs}
+func NewPendingBlock(parent database.Hash, number uint64, miner database.Address, txs []database.SignedTx) PendingBlock {
+	return PendingBlock{parent, number, uint64(time.Now().Unix()), miner, txs, database.Hash{}}
+}
+
+func (pb PendingBlock) block() database.Block {
+	block := database.NewBlock(pb.parent, pb.number, 0, pb.time, pb.miner, pb.txs)
+	block.Header.StateRoot = pb.stateRoot
+
+	return block
 }
 
 func Mine(ctx context.Context, pb PendingBlock, miningDifficulty uint) (database.Block, error) {
@@ -30,9 +37,8 @@ func Mine(ctx context.Context, pb PendingBlock, miningDifficulty uint) (database
 
 	start := time.Now()
 	attempt := 0
-	var block database.Block
+	block := pb.block()
 	var hash database.Hash
-	var nonce uint32
 
 	for !database.IsBlockHashValid(hash, miningDifficulty) {
 		select {
@@ -44,13 +50,12 @@ func Mine(ctx context.Context, pb PendingBlock, miningDifficulty uint) (database
 		}
 
 		attempt++
-		nonce = security.GenerateNonce()
+		block.Header.Nonce = security.GenerateNonce()
 
 		if attempt%1000000 == 0 || attempt == 1 {
 			fmt.Printf("Mining %d Pending TXs. Attempt: %d\n", len(pb.txs), attempt)
 		}
 
-		block = database.NewBlock(pb.parent, pb.number, nonce, pb.time, pb.miner, pb.txs)
 		blockHash, err := block.Hash()
 		if err != nil {
 			return database.Block{}, fmt.Errorf("couldn't mine block. %s", err.Error())
@@ -86,30 +86,30 @@ func TestMineWithTimeout(t *testing.T) {
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Microsecond*100)
 	defer cancel()
-	
+
 	_, err = Mine(ctx, pendingBlock, defaultTestMiningDifficulty)
 	if err == nil {
 		t.Fatal(err)
 	}
 }
 
-func generateKey() (*ecdsa.PrivateKey, ecdsa.PublicKey, common.Address, error) {
+func generateKey() (*ecdsa.PrivateKey, ecdsa.PublicKey, database.Address, error) {
 	privKey, err := ecdsa.GenerateKey(crypto.S256(), rand.Reader)
 	if err != nil {
-		return nil, ecdsa.PublicKey{}, common.Address{}, err
+		return nil, ecdsa.PublicKey{}, database.Address{}, err
 	}
 
 	pubKey := privKey.PublicKey
 	pubKeyBytes := elliptic.Marshal(crypto.S256(), pubKey.X, pubKey.Y)
 	pubKeyBytesHash := crypto.Keccak256(pubKeyBytes[1:])
 
-	account := common.BytesToAddress(pubKeyBytesHash[12:])
+	account := database.Address(common.BytesToAddress(pubKeyBytesHash[12:]))
 
 	return privKey, pubKey, account, nil
 }
 
-func createRandomPendingBlock(privKey *ecdsa.PrivateKey, acc common.Address) (PendingBlock, error) {
-	tx := database.NewBaseTx(acc, database.NewAccount("testKsBabaYagaAccount"), 1, 1, "")
+func createRandomPendingBlock(privKey *ecdsa.PrivateKey, acc database.Address) (PendingBlock, error) {
+	tx := database.NewBaseTx(acc, database.NewAccount("testKsBabaYagaAccount"), database.NewAmount(1), 1, "")
 	signedTx, err := wallet.SignTx(tx, privKey)
 	if err != nil {
 		return PendingBlock{}, err
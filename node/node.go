@@ -8,7 +8,6 @@ import (
 	"time"
 
 	"github.com/caddyserver/certmagic"
-	"github.com/ethereum/go-ethereum/common"
 
 	"github.com/jnsoft/gamma/database"
 )
@@ -24,25 +23,47 @@ const endpointStatus = "/node/status"
 
 const endpointSync = "/node/sync"
 const endpointSyncQueryKeyFromBlock = "fromBlock"
+const endpointSyncQueryKeyLimit = "limit"
+
+// syncPageDefaultLimit and syncPageMaxLimit bound the number of blocks of a /node/sync page.
+const syncPageDefaultLimit = 100
+const syncPageMaxLimit = 1000
 
 const endpointAddPeer = "/node/peer"
 const endpointAddPeerQueryKeyIP = "ip"
 const endpointAddPeerQueryKeyPort = "port"
 const endpointAddPeerQueryKeyMiner = "miner"
 const endpointAddPeerQueryKeyVersion = "version"
+const endpointAddPeerQueryKeyGenesis = "genesis"
+
+const endpointBalancesQueryKeyHeight = "height"
+const endpointBalancesQueryKeyHash = "hash"
+
+const endpointMinted = "/mint/supply"
+
+const endpointSupply = "/supply"
 
 const endpointBlockByNumberOrHash = "/block/"
 const endpointMempoolViewer = "/mempool/"
 
+const endpointTx = "/tx/"
+
+const endpointAccount = "/account/"
+const endpointAccountQueryKeyHeight = "height"
+const endpointAccountQueryKeyOffset = "offset"
+const endpointAccountQueryKeyLimit = "limit"
+
+const accountTxsDefaultLimit = 50
+const accountTxsMaxLimit = 1000
+
 const miningIntervalSeconds = 10
-const DefaultMiningDifficulty = 3
 
 type PeerNode struct {
-	IP          string         `json:"ip"`
-	Port        uint64         `json:"port"`
-	IsBootstrap bool           `json:"is_bootstrap"`
-	Account     common.Address `json:"account"`
-	NodeVersion string         `json:"node_version"`
+	IP          string           `json:"ip"`
+	Port        uint64           `json:"port"`
+	IsBootstrap bool             `json:"is_bootstrap"`
+	Account     database.Address `json:"account"`
+	NodeVersion string           `json:"node_version"`
 
 	// Whenever my node already established connection, sync with this Peer
 	connected bool
@@ -77,25 +98,29 @@ type Node struct {
 	newPendingTXs   chan database.SignedTx
 	nodeVersion     string
 
-	// Number of zeroes the hash must start with to be considered valid. Default 3
+	// Number of zeroes the hash must start with to be considered valid, from the genesis
 	miningDifficulty uint
 	isMining         bool
+
+	// how far into the future of the node clock a block may be stamped once TIP3 is active
+	maxBlockTimeDrift time.Duration
 }
 
-func New(dataDir string, ip string, port uint64, acc common.Address, bootstrap PeerNode, version string, miningDifficulty uint) *Node {
+func New(dataDir string, ip string, port uint64, acc database.Address, bootstrap PeerNode, version string) *Node {
 	knownPeers := make(map[string]PeerNode)
 
 	n := &Node{
-		dataDir:          dataDir,
-		info:             NewPeerNode(ip, port, false, acc, true, version),
-		knownPeers:       knownPeers,
-		pendingTXs:       make(map[string]database.SignedTx),
-		archivedTXs:      make(map[string]database.SignedTx),
-		newSyncedBlocks:  make(chan database.Block),
-		newPendingTXs:    make(chan database.SignedTx, 10000),
-		nodeVersion:      version,
-		isMining:         false,
-		miningDifficulty: miningDifficulty,
+		dataDir:         dataDir,
+		info:            NewPeerNode(ip, port, false, acc, true, version),
+		knownPeers:      knownPeers,
+		pendingTXs:      make(map[string]database.SignedTx),
+		archivedTXs:     make(map[string]database.SignedTx),
+		newSyncedBlocks: make(chan database.Block),
+		newPendingTXs:   make(chan database.SignedTx, 10000),
+		nodeVersion:     version,
+		isMining:        false,
+
+		maxBlockTimeDrift: database.DefaultMaxBlockTimeDrift,
 	}
 
 	n.AddPeer(bootstrap)
@@ -103,20 +128,28 @@ func New(dataDir string, ip string, port uint64, acc common.Address, bootstrap P
 	return n
 }
 
-func NewPeerNode(ip string, port uint64, isBootstrap bool, acc common.Address, connected bool, version string) PeerNode {
+// SetMaxBlockTimeDrift sets how far into the future of the node clock a block may be stamped, before Run.
+func (n *Node) SetMaxBlockTimeDrift(drift time.Duration) {
+	n.maxBlockTimeDrift = drift
+}
+
+func NewPeerNode(ip string, port uint64, isBootstrap bool, acc database.Address, connected bool, version string) PeerNode {
 	return PeerNode{ip, port, isBootstrap, acc, version, connected}
 }
 
 func (n *Node) Run(ctx context.Context, isSSLDisabled bool, sslEmail string) error {
 	fmt.Printf("Listening on: %s:%d\n", n.info.IP, n.info.Port)
 
-	state, err := database.NewStateFromDisk(n.dataDir, n.miningDifficulty)
+	state, err := database.NewStateFromDisk(n.dataDir)
 	if err != nil {
 		return err
 	}
 	defer state.Close()
 
+	state.SetBlockTimePolicy(n.maxBlockTimeDrift, time.Now)
+
 	n.state = state
+	n.miningDifficulty = state.MiningDifficulty()
 
 	pendingState := state.Copy()
 	n.pendingState = &pendingState
@@ -124,6 +157,7 @@ func (n *Node) Run(ctx context.Context, isSSLDisabled bool, sslEmail string) err
 	fmt.Println("Blockchain state:")
 	fmt.Printf("	- height: %d\n", n.state.LatestBlock().Header.Number)
 	fmt.Printf("	- hash: %s\n", n.state.LatestBlockHash().Hex())
+	fmt.Printf("	- chain: %s (genesis %s)\n", n.state.ChainID(), n.state.GenesisHash().Hex())
 
 	go n.sync(ctx)
 	go n.mine(ctx)
@@ -142,6 +176,14 @@ func (n *Node) serveHttp(ctx context.Context, isSSLDisabled bool, sslEmail strin
 		listBalancesHandler(w, r, n.state)
 	})
 
+	handler.HandleFunc(endpointMinted, func(w http.ResponseWriter, r *http.Request) {
+		mintedHandler(w, r, n.state)
+	})
+
+	handler.HandleFunc(endpointSupply, func(w http.ResponseWriter, r *http.Request) {
+		supplyHandler(w, r, n.state)
+	})
+
 	handler.HandleFunc("/tx/add", func(w http.ResponseWriter, r *http.Request) {
 		txAddHandler(w, r, n)
 	})
@@ -166,6 +208,14 @@ func (n *Node) serveHttp(ctx context.Context, isSSLDisabled bool, sslEmail strin
 		mempoolViewer(w, r, n.pendingTXs)
 	})
 
+	handler.HandleFunc(endpointTx, func(w http.ResponseWriter, r *http.Request) {
+		txHandler(w, r, n)
+	})
+
+	handler.HandleFunc(endpointAccount, func(w http.ResponseWriter, r *http.Request) {
+		accountHandler(w, r, n)
+	})
+
 	if isSSLDisabled {
 		server := &http.Server{Addr: fmt.Sprintf(":%d", n.info.Port), Handler: handler}
 
@@ -184,7 +234,17 @@ func (n *Node) serveHttp(ctx context.Context, isSSLDisabled bool, sslEmail strin
 	} else {
 		certmagic.DefaultACME.Email = sslEmail
 
-		return certmagic.HTTPS([]string{n.info.IP}, handler)
+		served := make(chan error, 1)
+		go func() {
+			served <- certmagic.HTTPS([]string{n.info.IP}, handler)
+		}()
+
+		select {
+		case err := <-served:
+			return err
+		case <-ctx.Done():
+			return nil
+		}
 	}
 }
 
@@ -235,6 +295,17 @@ func (n *Node) minePendingTXs(ctx context.Context) error {
 		n.getPendingTXsAsArray(),
 	)
 
+	// blocks may not precede the median time of the latest blocks, even if the node clock is behind
+	if median := n.state.MedianTime(); blockToMine.time < median {
+		blockToMine.time = median
+	}
+
+	stateRoot, err := n.state.NextStateRoot(blockToMine.block())
+	if err != nil {
+		return err
+	}
+	blockToMine.stateRoot = stateRoot
+
 	minedBlock, err := Mine(ctx, blockToMine, n.miningDifficulty)
 	if err != nil {
 		return err
@@ -332,6 +403,59 @@ func (n *Node) addBlock(block database.Block) error {
 	return nil
 }
 
+// importBlock adds a block synced from a peer, which may switch the main state to another branch.
+// Transactions orphaned by a reorganization which are still valid go back to the mempool.
+func (n *Node) importBlock(block database.Block) (database.ChainUpdate, error) {
+	update, err := n.state.ImportBlock(block)
+	if err != nil {
+		return update, err
+	}
+
+	if len(update.Added) == 0 {
+		return update, nil
+	}
+
+	// Reset the pending state
+	pendingState := n.state.Copy()
+	n.pendingState = &pendingState
+
+	if update.IsReorg() {
+		n.returnOrphanedTXs(update)
+	}
+
+	return update, nil
+}
+
+func (n *Node) returnOrphanedTXs(update database.ChainUpdate) {
+	included := make(map[database.Hash]bool)
+	for _, block := range update.Added {
+		for _, tx := range block.TXs {
+			txHash, _ := tx.Hash()
+			included[txHash] = true
+		}
+	}
+
+	for _, block := range update.Removed {
+		for _, tx := range block.TXs {
+			txHash, _ := tx.Hash()
+			if included[txHash] {
+				continue
+			}
+
+			err := n.validateTxBeforeAddingToMempool(tx)
+			if err != nil {
+				fmt.Printf("\t-dropping orphaned TX %s: %s\n", txHash.Hex(), err.Error())
+				continue
+			}
+
+			fmt.Printf("\t-returning orphaned TX to the mempool: %s\n", txHash.Hex())
+
+			delete(n.archivedTXs, txHash.Hex())
+			n.pendingTXs[txHash.Hex()] = tx
+		}
+	}
+}
+
 // validateTxBeforeAddingToMempool ensures the TX is authentic, with correct nonce, and the sender has sufficient
 // funds so we waste PoW resources on TX we can tell in advance are wrong.
 func (n *Node) validateTxBeforeAddingToMempool(tx database.SignedTx) error {
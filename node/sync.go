@@ -2,6 +2,7 @@ package node
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/url"
@@ -48,6 +49,15 @@ func (n *Node) doSync() {
 			continue
 		}
 
+		if status.GenesisHash != n.state.GenesisHash() {
+			fmt.Printf("ERROR: peer genesis '%s' differs from genesis '%s'\n", status.GenesisHash.Hex(), n.state.GenesisHash().Hex())
+			fmt.Printf("Peer '%s' was removed from KnownPeers\n", peer.TcpAddress())
+
+			n.RemovePeer(peer)
+
+			continue
+		}
+
 		err = n.joinKnownPeers(peer)
 		if err != nil {
 			fmt.Printf("ERROR: %s\n", err)
@@ -82,38 +92,91 @@ func (n *Node) syncBlocks(peer PeerNode, status StatusRes) error {
 		return nil
 	}
 
-	// If the peer has less blocks than us, ignore it
-	if status.Number < localBlockNumber {
-		return nil
-	}
-
-	// If it's the genesis block and we already synced it, ignore it
-	if status.Number == 0 && !n.state.LatestBlockHash().IsEmpty() {
+	// If the peer chain has no more work than ours, ignore it.
+	// On a tie we keep the branch we saw first.
+	if !n.state.LatestBlockHash().IsEmpty() && !outworks(status, n.state) {
 		return nil
 	}
 
-	// Display found 1 new block if we sync the genesis block 0
-	newBlocksCount := status.Number - localBlockNumber
-	if localBlockNumber == 0 && status.Number == 0 {
-		newBlocksCount = 1
+	if status.Number > localBlockNumber || n.state.LatestBlockHash().IsEmpty() {
+		// Display found 1 new block if we sync the genesis block 0
+		newBlocksCount := status.Number - localBlockNumber
+		if localBlockNumber == 0 && status.Number == 0 {
+			newBlocksCount = 1
+		}
+		fmt.Printf("Found %d new blocks from Peer %s\n", newBlocksCount, peer.TcpAddress())
+	} else {
+		fmt.Printf("Found a branch with more work up to block %d from Peer %s\n", status.Number, peer.TcpAddress())
 	}
-	fmt.Printf("Found %d new blocks from Peer %s\n", newBlocksCount, peer.TcpAddress())
 
-	blocks, err := fetchBlocksFromPeer(peer, n.state.LatestBlockHash())
+	page, err := n.fetchNewBlocks(peer)
 	if err != nil {
 		return err
 	}
 
-	for _, block := range blocks {
-		err = n.addBlock(block)
+	// import page by page, so a node syncing from genesis never holds the whole chain in memory
+	for {
+		for _, block := range page.Blocks {
+			update, err := n.importBlock(block)
+			if err != nil {
+				return err
+			}
+
+			if len(update.Added) > 0 {
+				n.newSyncedBlocks <- block
+			}
+		}
+
+		if !page.More {
+			return nil
+		}
+
+		page, err = fetchBlocksFromPeer(peer, page.Cursor)
 		if err != nil {
 			return err
 		}
+	}
+}
 
-		n.newSyncedBlocks <- block
+// outworks tells if the peer chain took more work than the local one. Peers which don't report their chain work
+// are compared by height.
+func outworks(status StatusRes, state *database.State) bool {
+	if status.Work == nil {
+		return status.Number > state.LatestBlock().Header.Number
 	}
 
-	return nil
+	return status.Work.Cmp(state.TotalWork()) > 0
+}
+
+// fetchNewBlocks asks the peer for the first page of blocks after our tip. If the peer doesn't know our tip, because
+// we are on another branch, it walks back our chain with a doubling step until it reaches a block the peer knows.
+func (n *Node) fetchNewBlocks(peer PeerNode) (SyncRes, error) {
+	from := n.state.LatestBlockHash()
+	height := n.state.LatestBlock().Header.Number
+	step := uint64(1)
+
+	for {
+		page, err := fetchBlocksFromPeer(peer, from)
+		if err != nil || len(page.Blocks) > 0 || from.IsEmpty() {
+			return page, err
+		}
+
+		if height < step {
+			from = database.Hash{}
+			continue
+		}
+
+		height -= step
+		step *= 2
+
+		blockFs, err := n.state.GetBlockByHeightOrHash(height, "")
+		if err != nil {
+			return SyncRes{}, err
+		}
+		from = blockFs.Key
+
+		fmt.Printf("Peer %s doesn't know our tip, looking for a common block at height %d\n", peer.TcpAddress(), height)
+	}
 }
 
 func (n *Node) syncKnownPeers(status StatusRes) error {
@@ -145,7 +208,7 @@ func (n *Node) joinKnownPeers(peer PeerNode) error {
 	}
 
 	p_url := fmt.Sprintf(
-		"%s://%s%s?%s=%s&%s=%d&%s=%s&%s=%s",
+		"%s://%s%s?%s=%s&%s=%d&%s=%s&%s=%s&%s=%s",
 		peer.ApiProtocol(),
 		peer.TcpAddress(),
 		endpointAddPeer,
@@ -157,6 +220,8 @@ func (n *Node) joinKnownPeers(peer PeerNode) error {
 		n.info.Account.String(),
 		endpointAddPeerQueryKeyVersion,
 		url.QueryEscape(n.info.NodeVersion),
+		endpointAddPeerQueryKeyGenesis,
+		n.state.GenesisHash().Hex(),
 	)
 
 	res, err := http.Get(p_url)
@@ -201,28 +266,36 @@ func queryPeerStatus(peer PeerNode) (StatusRes, error) {
 	return statusRes, nil
 }
 
-func fetchBlocksFromPeer(peer PeerNode, fromBlock database.Hash) ([]database.Block, error) {
+// fetchBlocksFromPeer fetches a page of the peer blocks after the cursor hash, decoding the response as it's streamed.
+func fetchBlocksFromPeer(peer PeerNode, fromBlock database.Hash) (SyncRes, error) {
 	fmt.Printf("Importing blocks from Peer %s...\n", peer.TcpAddress())
 
 	url := fmt.Sprintf(
-		"%s://%s%s?%s=%s",
+		"%s://%s%s?%s=%s&%s=%d",
 		peer.ApiProtocol(),
 		peer.TcpAddress(),
 		endpointSync,
 		endpointSyncQueryKeyFromBlock,
 		fromBlock.Hex(),
+		endpointSyncQueryKeyLimit,
+		syncPageDefaultLimit,
 	)
 
 	res, err := http.Get(url)
 	if err != nil {
-		return nil, err
+		return SyncRes{}, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return SyncRes{}, readRes(res, nil)
 	}
 
 	syncRes := SyncRes{}
-	err = readRes(res, &syncRes)
+	err = json.NewDecoder(res.Body).Decode(&syncRes)
 	if err != nil {
-		return nil, err
+		return SyncRes{}, fmt.Errorf("unable to decode the blocks of peer %s. %s", peer.TcpAddress(), err.Error())
 	}
 
-	return syncRes.Blocks, nil
-}
\ No newline at end of file
+	return syncRes, nil
+}
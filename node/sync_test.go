@@ -0,0 +1,146 @@
+package node
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+
+	"github.com/jnsoft/gamma/database"
+	"github.com/jnsoft/gamma/wallet"
+)
+
+const testSyncGenesis = `{
+	"genesis_time": "2023-03-11T00:00:00.000000000Z",
+	"chain_id": "gamma-test",
+	"symbol": "TGL",
+	"balances": {"%s": 1000},
+	"difficulty": 1,
+	"block_reward": 100
+}`
+
+// newTestSyncState returns the state of a new data dir whose genesis funds the account.
+func newTestSyncState(t *testing.T, acc database.Address) *database.State {
+	dataDir := t.TempDir()
+
+	if err := database.InitDataDirIfNotExists(dataDir, []byte(fmt.Sprintf(testSyncGenesis, acc.String()))); err != nil {
+		t.Fatal(err)
+	}
+
+	state, err := database.NewStateFromDisk(dataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { state.Close() })
+
+	return state
+}
+
+func TestSyncBlocksPageByPage(t *testing.T) {
+	privKey, _, acc, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := newTestSyncState(t, acc)
+
+	for i := uint(1); i <= 5; i++ {
+		tx := database.NewBaseTx(acc, database.NewAccount("0x02"), database.NewAmount(1), i, "")
+		signedTx, err := wallet.SignTx(tx, privKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pb := NewPendingBlock(source.LatestBlockHash(), source.NextBlockNumber(), acc, []database.SignedTx{signedTx})
+		if pb.stateRoot, err = source.NextStateRoot(pb.block()); err != nil {
+			t.Fatal(err)
+		}
+
+		block, err := Mine(context.Background(), pb, source.MiningDifficulty())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := source.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sourceNode := &Node{state: source}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		syncHandler(w, r, sourceNode)
+	}))
+	defer server.Close()
+
+	// the pages chain through their cursor until no more blocks follow
+	cursor := database.Hash{}
+	heights := make([]uint64, 0)
+	for {
+		res, err := http.Get(fmt.Sprintf("%s%s?%s=%s&%s=2", server.URL, endpointSync, endpointSyncQueryKeyFromBlock, cursor.Hex(), endpointSyncQueryKeyLimit))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		page := SyncRes{}
+		if err := readRes(res, &page); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(page.Blocks) > 2 {
+			t.Fatalf("page should hold at most 2 blocks, got %d", len(page.Blocks))
+		}
+
+		for _, block := range page.Blocks {
+			heights = append(heights, block.Header.Number)
+		}
+		cursor = page.Cursor
+
+		if !page.More {
+			break
+		}
+	}
+
+	if len(heights) != 5 || heights[4] != 4 || cursor != source.LatestBlockHash() {
+		t.Fatalf("pages should hold blocks 0 to 4 and end at the tip, got %v", heights)
+	}
+
+	res, err := http.Get(fmt.Sprintf("%s%s?%s=%s&%s=%d", server.URL, endpointSync, endpointSyncQueryKeyFromBlock, cursor.Hex(), endpointSyncQueryKeyLimit, syncPageMaxLimit+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := readRes(res, &SyncRes{}); err == nil {
+		t.Fatal("page larger than the max limit should be rejected")
+	}
+
+	// a new node pulls the pages of the peer until it's caught up
+	serverURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.ParseUint(serverURL.Port(), 10, 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	peer := NewPeerNode(serverURL.Hostname(), port, true, acc, true, "test")
+
+	target := newTestSyncState(t, acc)
+	targetNode := &Node{state: target, newSyncedBlocks: make(chan database.Block, 10)}
+	pendingState := target.Copy()
+	targetNode.pendingState = &pendingState
+
+	status := StatusRes{Hash: source.LatestBlockHash(), Number: source.LatestBlock().Header.Number, Work: source.TotalWork()}
+	if err := targetNode.syncBlocks(peer, status); err != nil {
+		t.Fatal(err)
+	}
+
+	if target.LatestBlockHash() != source.LatestBlockHash() {
+		t.Fatalf("synced node should reach the peer tip, it's at block %d", target.LatestBlock().Header.Number)
+	}
+
+	if outworks(status, target) {
+		t.Fatal("peer chain shouldn't outwork the chain synced from it")
+	}
+}
@@ -6,8 +6,12 @@ import (
 	"time"
 
 	"github.com/jnsoft/gamma/database"
+	"github.com/jnsoft/gamma/util/security"
+	"github.com/jnsoft/gamma/wallet"
 )
 
+const miningDifficulty = 1
+
 func main() {
 
 	//var v1, v2 database.Address
@@ -24,47 +28,68 @@ func main() {
 	fmt.Println(t.Day())
 	fmt.Println(t.Year())
 
-	// TODO: hantera
-	// signera transaktioner?
-	//
-
-	state, err := database.NewStateFromDisk("/tmp/gammadb", 1) // kommer skapa /tmp/gammadb/database/ och filer där
+	state, err := database.NewStateFromDisk("/tmp/gammadb") // kommer skapa /tmp/gammadb/database/ och filer där
 	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 	defer state.Close()
+	state.ChangeMiningDifficulty(miningDifficulty)
 
-	// database.NewSimpleTx(database.Address(hexutil.MustDecode(a1)), database.Address(hexutil.MustDecode(a1)), 1, "")
-
-	block0 := database.NewSimpleBlock(
-		database.Hash{},
-		0,
-		database.ToAddress(database.A0),
-		[]database.SimpleTx{
-			database.NewSimpleTxStringAddress(database.A1, database.A2, 3, ""),
-			database.NewSimpleTxStringAddress(database.A1, database.A2, 5, ""),
-		},
-	)
-
-	state.AddSimpleBlock(block0)
-	block0hash, _ := state.Persist()
-
-	block1 := database.NewSimpleBlock(
-		block0hash,
-		1,
-		database.ToAddress(database.A0),
-		[]database.SimpleTx{
-			database.NewSimpleTxStringAddress(database.A1, database.A2, 2000, ""),
-			database.NewSimpleTxStringAddress(database.A1, database.A1, 100, "mint"),
-			database.NewSimpleTxStringAddress(database.A2, database.A1, 1, ""),
-			database.NewSimpleTxStringAddress(database.A2, database.A3, 1000, ""),
-			database.NewSimpleTxStringAddress(database.A2, database.A1, 50, ""),
-			database.NewSimpleTxStringAddress(database.A1, database.A1, 100, "mint"),
-		},
-	)
-
-	state.AddSimpleBlock(block1)
-	state.Persist()
+	key, err := wallet.NewRandomKey()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	miner := database.Address(key.Address)
+
+	// an empty block only pays the block reward to the miner, giving it something to spend
+	block0 := mine(state, database.NewBlock(state.LatestBlockHash(), state.NextBlockNumber(), 0, uint64(time.Now().Unix()), miner, nil))
 
+	block0hash, err := state.AddBlock(block0)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	tx := database.NewBaseTx(miner, database.ToAddress(database.A1), database.NewAmount(3), state.GetNextAccountNonce(miner), "")
+	if !state.Rules().IsTIP1 {
+		tx.Gas, tx.GasPrice = 0, database.Amount{}
+	}
+
+	signedTx, err := wallet.SignTx(tx, key.PrivateKey)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	block1 := mine(state, database.NewBlock(block0hash, state.NextBlockNumber(), 0, uint64(time.Now().Unix()), miner, []database.SignedTx{signedTx}))
+
+	if _, err := state.AddBlock(block1); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func mine(state *database.State, b database.Block) database.Block {
+	stateRoot, err := state.NextStateRoot(b)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	b.Header.StateRoot = stateRoot
+
+	for {
+		b.Header.Nonce = security.GenerateNonce()
+
+		hash, err := b.Hash()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		if database.IsBlockHashValid(hash, miningDifficulty) {
+			return b
+		}
+	}
 }
@@ -22,19 +22,19 @@ func GetKeystoreDirPath(dataDir string) string {
 	return filepath.Join(dataDir, keystoreDirName)
 }
 
-func NewKeystoreAccount(dataDir, password string) (common.Address, error) {
+func NewKeystoreAccount(dataDir, password string) (database.Address, error) {
 	ks := keystore.NewKeyStore(GetKeystoreDirPath(dataDir), keystore.StandardScryptN, keystore.StandardScryptP)
 	acc, err := ks.NewAccount(password)
 	if err != nil {
-		return common.Address{}, err
+		return database.Address{}, err
 	}
 
-	return acc.Address, nil
+	return database.Address(acc.Address), nil
 }
 
-func SignTxWithKeystoreAccount(tx database.Tx, acc common.Address, pwd, keystoreDir string) (database.SignedTx, error) {
+func SignTxWithKeystoreAccount(tx database.Tx, acc database.Address, pwd, keystoreDir string) (database.SignedTx, error) {
 	ks := keystore.NewKeyStore(keystoreDir, keystore.StandardScryptN, keystore.StandardScryptP)
-	ksAccount, err := ks.Find(accounts.Account{Address: acc})
+	ksAccount, err := ks.Find(accounts.Account{Address: common.Address(acc)})
 	if err != nil {
 		return database.SignedTx{}, err
 	}
@@ -57,6 +57,7 @@ func SignTxWithKeystoreAccount(tx database.Tx, acc common.Address, pwd, keystore
 	return signedTx, nil
 }
 
+// SignTx signs the encoding of the tx, which covers its chain ID, so the signed tx is only valid on that chain.
 func SignTx(tx database.Tx, privKey *ecdsa.PrivateKey) (database.SignedTx, error) {
 	rawTx, err := tx.Encode()
 	if err != nil {
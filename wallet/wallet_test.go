@@ -125,7 +125,7 @@ func TestSignTxWithKeystoreAccount(t *testing.T) {
 		return
 	}
 
-	tx := database.NewBaseTx(andrej, babaYaga, 100, 1, "")
+	tx := database.NewBaseTx(andrej, babaYaga, database.NewAmount(100), 1, "")
 
 	signedTx, err := SignTxWithKeystoreAccount(tx, andrej, testKeystoreAccountsPwd, GetKeystoreDirPath(tmpDir))
 	if err != nil {
@@ -177,7 +177,7 @@ func TestSignForgedTxWithKeystoreAccount(t *testing.T) {
 		return
 	}
 
-	forgedTx := database.NewBaseTx(babaYaga, hacker, 100, 1, "")
+	forgedTx := database.NewBaseTx(babaYaga, hacker, database.NewAmount(100), 1, "")
 
 	signedTx, err := SignTxWithKeystoreAccount(forgedTx, hacker, testKeystoreAccountsPwd, GetKeystoreDirPath(tmpDir))
 	if err != nil {